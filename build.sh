@@ -93,8 +93,11 @@ build_image() {
   echo "Context: $context_path"
   echo "Image: $image_name"
 
-  # Build the image
+  # Build the image, embedding the build information reported by "version" and /version
   docker build $NO_CACHE $PLATFORM \
+    --build-arg VERSION="$TAG" \
+    --build-arg COMMIT="$(git rev-parse --short HEAD 2>/dev/null || echo unknown)" \
+    --build-arg BUILD_DATE="$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
     -f "$dockerfile_path" \
     -t "$image_name" \
     "$context_path"
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// countScript counts KEYS[i] as ARGV[i] says, inside one script so Redis runs no other
+// command in between and a job moving from wait to active is counted exactly once. Keys of
+// the wrong type yield an error entry rather than failing the script, so the caller can
+// name the key. ARGV[#KEYS + 1] is the ZCOUNT bound for "zcount".
+var countScript = redis.NewScript(`
+local dueMax = ARGV[#KEYS + 1]
+local counts = {}
+for i, key in ipairs(KEYS) do
+  local op = ARGV[i]
+  if op == 'llen' then
+    counts[i] = redis.pcall('LLEN', key)
+  elseif op == 'zcard' then
+    counts[i] = redis.pcall('ZCARD', key)
+  elseif op == 'zcount' then
+    counts[i] = redis.pcall('ZCOUNT', key, '-inf', dueMax)
+  elseif op == 'stalled' then
+    if redis.call('TYPE', key).ok == 'list' then
+      counts[i] = redis.call('LLEN', key)
+    else
+      counts[i] = redis.pcall('SCARD', key)
+    end
+  elseif op == 'paused' then
+    local keyType = redis.call('TYPE', key).ok
+    if keyType == 'hash' then
+      counts[i] = redis.call('HEXISTS', key, 'paused')
+    elseif keyType == 'none' then
+      counts[i] = 0
+    else
+      counts[i] = 1
+    end
+  end
+end
+return counts
+`)
+
+// countedKey is one key counted by countScript and where its count goes
+type countedKey struct {
+	op       string
+	field    string
+	key      string
+	expected string
+	apply    func(count int64)
+	optional bool // a failure is recorded in finishedErr instead of failing the read
+}
+
+// fetchQueueLengthsAtomic is the atomicRead variant of fetchQueueLengths. It counts every
+// configured key, the paused flag and the finished sets with countScript, which go-redis
+// runs with EVALSHA and loads on first use. On Redis Cluster the keys must share a hash
+// slot, as BullMQ's own keys do when the queue uses a hash tag.
+func (s *server) fetchQueueLengthsAtomic(ctx context.Context, client redisCmdable, keys queueKeys) (queueLengths, error) {
+	lengths := queueLengths{queues: make([]queueLengths, len(keys.wait))}
+
+	var counted []countedKey
+	add := func(op, field, expected string, list []string, apply func(i int, count int64)) {
+		for i, key := range list {
+			counted = append(counted, countedKey{op: op, field: field, key: key, expected: expected,
+				apply: func(count int64) { apply(i, count) }})
+		}
+	}
+	add("llen", "waitList", "list", keys.wait, func(i int, n int64) { lengths.wait += n; lengths.queue(i).wait += n })
+	add("llen", "activeList", "list", keys.active, func(i int, n int64) { lengths.active += n; lengths.queue(i).active += n })
+	delayedOp := "zcard"
+	if keys.delayedDue {
+		delayedOp = "zcount"
+	}
+	add(delayedOp, "delayedSet", "sorted set", keys.delayed, func(i int, n int64) { lengths.delayed += n; lengths.queue(i).delayed += n })
+	add("zcard", "prioritizedSet", "sorted set", keys.prioritized, func(i int, n int64) { lengths.prioritized += n; lengths.queue(i).prioritized += n })
+	add("stalled", "stalledSet", "set or list", keys.stalled, func(i int, n int64) { lengths.stalled += n; lengths.queue(i).stalled += n })
+	add("zcard", "waitingChildrenSet", "sorted set", keys.waitingChildren, func(i int, n int64) {
+		lengths.waitingChildren += n
+		lengths.queue(i).waitingChildren += n
+	})
+	if keys.paused != "" {
+		add("paused", "pausedKey", "hash or string", []string{keys.paused}, func(_ int, n int64) { lengths.paused = n > 0 })
+		// Counted after the flag, so the paused list is only added to wait while paused
+		for i, key := range keys.pausedLists() {
+			if key != "" {
+				counted = append(counted, countedKey{op: "llen", field: "waitList", key: key, expected: "list",
+					apply: func(n int64) {
+						if lengths.paused {
+							lengths.wait += n
+							lengths.queue(i).wait += n
+						}
+					}})
+			}
+		}
+	}
+	finishedStart := len(counted)
+	add("zcard", "completedSet", "sorted set", keys.completed, func(_ int, n int64) { lengths.finished += n })
+	add("zcard", "failedSet", "sorted set", keys.failed, func(_ int, n int64) { lengths.finished += n; lengths.failed += n })
+	for i := finishedStart; i < len(counted); i++ {
+		counted[i].optional = true
+	}
+
+	scriptKeys := make([]string, 0, len(counted))
+	args := make([]interface{}, 0, len(counted)+1)
+	for _, c := range counted {
+		scriptKeys = append(scriptKeys, c.key)
+		args = append(args, c.op)
+	}
+	args = append(args, delayedDueMax(time.Now()))
+
+	var results []interface{}
+	err := s.redisOp(ctx, "queue_script", func(ctx context.Context) (err error) {
+		results, err = countScript.Run(ctx, client, scriptKeys, args...).Slice()
+		return err
+	})
+	if err != nil {
+		return queueLengths{}, fmt.Errorf("failed to count queue keys atomically: %w", err)
+	}
+	if len(results) != len(counted) {
+		return queueLengths{}, fmt.Errorf("count script returned %d results for %d keys", len(results), len(counted))
+	}
+
+	for i, c := range counted {
+		switch result := results[i].(type) {
+		case int64:
+			c.apply(result)
+		case error:
+			err := s.keyReadError(c.field, c.key, c.expected, result)
+			if !c.optional {
+				return queueLengths{}, err
+			}
+			if lengths.finishedErr == nil {
+				lengths.finishedErr = err
+			}
+		default:
+			return queueLengths{}, fmt.Errorf("count script returned %T for %s '%s'", result, c.field, c.key)
+		}
+	}
+
+	// Markers are discounted after the snapshot; a short list is rarely touched in between
+	if keys.excludeMarkers {
+		for i, key := range keys.wait {
+			waitLen := lengths.queue(i).wait
+			if waitLen <= 0 || waitLen > markerScanLimit {
+				continue
+			}
+			markers, err := s.countMarkers(ctx, client, key)
+			if err != nil {
+				return queueLengths{}, err
+			}
+			lengths.wait -= markers
+			lengths.queue(i).wait -= markers
+		}
+	}
+	return lengths, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errCircuitOpen is returned instead of reading a Redis endpoint whose circuit breaker is open
+var errCircuitOpen = errors.New("redis circuit breaker is open")
+
+// Fallbacks for redisFailureFallback, what a trigger reports while its circuit is open
+const (
+	fallbackError    = "error"
+	fallbackZero     = "zero"
+	fallbackReplicas = "replicas"
+)
+
+// circuitBreaker stops queue reads from one Redis endpoint after threshold consecutive
+// failures, so a failover is not met with a storm of commands that each wait out
+// REDIS_OP_TIMEOUT. While open, reads fail fast with errCircuitOpen. Once cooldown has
+// passed a single read goes through as a probe: success closes the breaker, and failure
+// keeps it open for another cooldown.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	addr      string
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+// allow returns errCircuitOpen while the breaker is open and no probe is due. A nil
+// breaker, when CIRCUIT_BREAKER_THRESHOLD is 0, always allows.
+func (b *circuitBreaker) allow(now time.Time) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	if wait := b.cooldown - now.Sub(b.openedAt); wait > 0 || b.probing {
+		return fmt.Errorf("%w after %d consecutive failures of %s; next probe in %s",
+			errCircuitOpen, b.failures, b.addr, max(wait, 0).Round(time.Second))
+	}
+	b.probing = true
+	slog.Info("Probing Redis through the open circuit breaker", "redis", b.addr)
+	return nil
+}
+
+// record counts the outcome of a read that allow let through. Only failures of the
+// connection count: error replies and invalid metadata say nothing about Redis health,
+// and a canceled read or one that never got a MAX_CONCURRENT_REDIS_OPS slot says nothing
+// at all.
+func (b *circuitBreaker) record(err error, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if errors.Is(err, context.Canceled) || errors.Is(err, errRedisSaturated) {
+		return
+	}
+	if !isConnectionFailure(err) {
+		if b.failures >= b.threshold {
+			slog.Info("Redis circuit breaker closed", "redis", b.addr)
+			redisCircuitOpenGauge.WithLabelValues(b.addr).Set(0)
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	switch {
+	case b.failures == b.threshold:
+		slog.Warn("Redis circuit breaker opened", "redis", b.addr, "failures", b.failures, "cooldown", b.cooldown, "error", err)
+		redisCircuitOpenGauge.WithLabelValues(b.addr).Set(1)
+	case b.failures > b.threshold:
+		slog.Warn("Redis probe failed, circuit breaker stays open", "redis", b.addr, "cooldown", b.cooldown, "error", err)
+	default:
+		return
+	}
+	b.openedAt = now
+}
+
+// isConnectionFailure reports whether err means Redis could not be reached or answered too
+// slowly, rather than that the request itself was wrong
+func isConnectionFailure(err error) bool {
+	if err == nil || isRedisReplyError(err) {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unavailable:
+		return true
+	}
+	return false
+}
+
+// circuitBreakers holds a circuitBreaker per Redis endpoint, the default client and each
+// endpoint overridden in trigger metadata
+type circuitBreakers struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	byAddr    map[string]*circuitBreaker
+}
+
+// newCircuitBreakers returns nil when threshold is 0, which disables the breakers
+func newCircuitBreakers(threshold int, cooldown time.Duration) *circuitBreakers {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreakers{threshold: threshold, cooldown: cooldown, byAddr: map[string]*circuitBreaker{}}
+}
+
+// get returns the breaker for the endpoint of o, creating it on first use
+func (c *circuitBreakers) get(o redisOverride) *circuitBreaker {
+	if c == nil {
+		return nil
+	}
+	addr := "default"
+	if o.host != "" {
+		addr = net.JoinHostPort(o.host, o.port)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.byAddr[addr]
+	if !ok {
+		b = &circuitBreaker{addr: addr, threshold: c.threshold, cooldown: c.cooldown}
+		c.byAddr[addr] = b
+	}
+	return b
+}
+
+// circuitFallback returns the value to report in place of err, when err is an open
+// circuit and the trigger asked for a fallback other than the error
+func (m scalerMetadata) circuitFallback(err error) (float64, bool) {
+	if !errors.Is(err, errCircuitOpen) {
+		return 0, false
+	}
+	switch m.redisFallback {
+	case fallbackZero:
+		return 0, true
+	case fallbackReplicas:
+		// The HPA divides by targetSize, so this asks for exactly fallbackReplicas pods
+		return float64(m.fallbackReplicas) * m.targetSize, true
+	}
+	return 0, false
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+)
+
+const usage = `Usage: redis-bull-scaler [command] [flags]
+
+Commands:
+  serve     Run the KEDA external scaler gRPC server (the default)
+  check     Check Redis connectivity, and run IsActive and GetMetrics once for a queue
+  version   Print the version, git commit and build date (also --version)
+
+Run "redis-bull-scaler <command> -h" for the flags of a command.
+`
+
+// main dispatches to a subcommand. Without one, or when the first argument is a flag, it
+// serves, so existing deployments that pass only flags keep working. --version is the
+// version subcommand.
+func main() {
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 && (args[0] == "--version" || args[0] == "-version") {
+		command = "version"
+	} else if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		runServe(args)
+	case "check":
+		os.Exit(runCheck(args))
+	case "version":
+		fmt.Println(versionString())
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+// metadataFlag collects repeated -metadata key=value flags into trigger metadata
+type metadataFlag map[string]string
+
+func (m metadataFlag) String() string {
+	pairs := make([]string, 0, len(m))
+	for key, value := range m {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m metadataFlag) Set(pair string) error {
+	key, value, ok := strings.Cut(pair, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("must be key=value, got: %s", pair)
+	}
+	m[key] = value
+	return nil
+}
+
+// checkReport is what the check subcommand found, printed as text or JSON
+type checkReport struct {
+	Redis       string        `json:"redis"`
+	Queue       string        `json:"queue,omitempty"`
+	Lengths     *checkLengths `json:"lengths,omitempty"`
+	IsActive    *bool         `json:"isActive,omitempty"`
+	MetricSpecs []checkMetric `json:"metricSpecs,omitempty"`
+	Metrics     []checkMetric `json:"metrics,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// checkLengths are the queue counts in a checkReport
+type checkLengths struct {
+	Wait        int64 `json:"wait"`
+	Active      int64 `json:"active"`
+	Delayed     int64 `json:"delayed"`
+	Prioritized int64 `json:"prioritized"`
+	Stalled     int64 `json:"stalled"`
+	Total       int64 `json:"total"`
+	Paused      bool  `json:"paused"`
+}
+
+// checkMetric is a metric spec's target or a metric value in a checkReport
+type checkMetric struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+// runCheck is the "check" subcommand. It uses the same env vars and CONFIG_FILE as serve
+// and pings Redis. With -queue or -metadata it then runs IsActive, GetMetricSpec and
+// GetMetrics once with that trigger metadata, through the same handlers KEDA calls, so an
+// operator can debug "why isn't my job scaling" from a shell in the pod. It prints the
+// report, as JSON with -json, and returns the exit code: 1 when any step fails.
+func runCheck(args []string) int {
+	metadata := metadataFlag{}
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	queue := flags.String("queue", "", "queue name, shorthand for -metadata queueName=<name>")
+	flags.Var(metadata, "metadata", "trigger metadata as key=value, repeatable")
+	timeout := flags.Duration("timeout", 10*time.Second, "time allowed for the Redis reads")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+	if *queue != "" {
+		metadata["queueName"] = *queue
+	}
+
+	setupLogging()
+	loadConfigFile()
+	startEmbeddedRedis(false)
+	s := NewServer()
+	defer s.redisClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	report, err := s.check(ctx, &pb.ScaledObjectRef{Name: "check", Namespace: "check", ScalerMetadata: metadata})
+	if err != nil {
+		report.Error = err.Error()
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		printCheckReport(report)
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// check fills in a checkReport for ref step by step, stopping at the first step that fails
+func (s *server) check(ctx context.Context, ref *pb.ScaledObjectRef) (checkReport, error) {
+	var report checkReport
+	start := time.Now()
+	if err := s.redisClient.Ping(ctx).Err(); err != nil {
+		report.Redis = "unreachable"
+		return report, err
+	}
+	report.Redis = "ok (" + time.Since(start).Round(time.Millisecond).String() + ")"
+	// The startup Ping may have failed where this one succeeded
+	s.redisConn.set(nil)
+	if err := s.checkRedisAccess(ctx); err != nil {
+		report.Redis = "missing permissions"
+		return report, err
+	}
+
+	if len(ref.ScalerMetadata) == 0 {
+		return report, nil
+	}
+	meta, err := validateScalerMetadata(ref.Namespace, ref.ScalerMetadata)
+	if err != nil {
+		return report, err
+	}
+	report.Queue = meta.keys.name()
+
+	s.checkKeyTypes(ctx, meta.keys, requestLogger(ctx, "Check", ref))
+	lengths, err := s.readQueueLengths(ctx, meta.keys)
+	if err != nil {
+		return report, err
+	}
+	report.Lengths = &checkLengths{Wait: lengths.wait, Active: lengths.active, Delayed: lengths.delayed,
+		Prioritized: lengths.prioritized, Stalled: lengths.stalled, Total: lengths.total(), Paused: lengths.paused}
+
+	active, err := s.IsActive(ctx, ref)
+	if err != nil {
+		return report, err
+	}
+	report.IsActive = &active.Result
+
+	specs, err := s.GetMetricSpec(ctx, ref)
+	if err != nil {
+		return report, err
+	}
+	for _, spec := range specs.MetricSpecs {
+		report.MetricSpecs = append(report.MetricSpecs, checkMetric{Name: spec.MetricName, Value: spec.TargetSizeFloat})
+	}
+
+	metrics, err := s.GetMetrics(ctx, &pb.GetMetricsRequest{ScaledObjectRef: ref, MetricName: specs.MetricSpecs[0].MetricName})
+	if err != nil {
+		return report, err
+	}
+	for _, value := range metrics.MetricValues {
+		report.Metrics = append(report.Metrics, checkMetric{Name: value.MetricName, Value: value.MetricValueFloat})
+	}
+	return report, nil
+}
+
+// printCheckReport prints a checkReport as one line per step
+func printCheckReport(report checkReport) {
+	fmt.Printf("redis: %s\n", report.Redis)
+	if report.Queue != "" {
+		fmt.Printf("queue: %s\n", report.Queue)
+	}
+	if l := report.Lengths; l != nil {
+		fmt.Printf("lengths: wait=%d active=%d delayed=%d prioritized=%d stalled=%d total=%d paused=%t\n",
+			l.Wait, l.Active, l.Delayed, l.Prioritized, l.Stalled, l.Total, l.Paused)
+	}
+	if report.IsActive != nil {
+		fmt.Printf("isActive: %t\n", *report.IsActive)
+	}
+	for _, spec := range report.MetricSpecs {
+		fmt.Printf("metricSpec: %s target=%g\n", spec.Name, spec.Value)
+	}
+	for _, value := range report.Metrics {
+		fmt.Printf("metric: %s value=%g\n", value.Name, value.Value)
+	}
+	if report.Error != "" {
+		fmt.Printf("FAILED: %s\n", report.Error)
+	}
+}
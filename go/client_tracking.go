@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// trackingInvalidateChannel is where Redis publishes client tracking invalidations to
+// RESP2 connections
+const trackingInvalidateChannel = "__redis__:invalidate"
+
+// trackedEntry is a cached queue length read and the keys it was read from
+type trackedEntry struct {
+	lengths queueLengths
+	keys    []string
+	readAt  time.Time
+}
+
+// trackedKey lists the cache entries read from a key, and the sequence number of the last
+// invalidation of the key since it was first read
+type trackedKey struct {
+	ids         map[string]struct{}
+	invalidated uint64
+	added       time.Time
+}
+
+// trackingCache keeps queue lengths until Redis reports that one of their keys changed,
+// using Redis 6 client tracking in broadcasting mode: Redis sends the name of every
+// modified key under the tracked prefixes to one connection, subscribed to
+// __redis__:invalidate, so polls for idle queues cost no Redis command at all. Entries are
+// still dropped after maxAge, bounding the damage of a missed invalidation, and the whole
+// cache is dropped whenever the invalidation connection is lost.
+type trackingCache struct {
+	mu        sync.Mutex
+	maxAge    time.Duration
+	entries   map[string]trackedEntry
+	keys      map[string]*trackedKey
+	seq       uint64
+	listening bool
+	lastSweep time.Time
+
+	client *redis.Client
+	pubsub *redis.PubSub
+}
+
+// startClientTracking opens the invalidation connection, a copy of base's connection
+// settings that turns on tracking for prefixes with itself as the redirect target before
+// subscribing. go-redis reconnects it after a failure, which runs OnConnect again.
+func startClientTracking(base redisCmdable, prefixes []string, maxAge time.Duration) *trackingCache {
+	client, ok := base.(*redis.Client)
+	if !ok {
+		fatal("REDIS_CLIENT_TRACKING is not supported in Redis Cluster mode")
+	}
+	if getEnvBool("REDIS_READ_FROM_REPLICAS", false) || os.Getenv("REDIS_REPLICA_ADDRS") != "" {
+		fatal("REDIS_CLIENT_TRACKING cannot be combined with reads from replicas, which lag the invalidations")
+	}
+	if maxAge <= 0 {
+		fatal("Invalid REDIS_CLIENT_TRACKING_MAX_AGE: must be greater than zero")
+	}
+
+	c := &trackingCache{
+		maxAge:    maxAge,
+		entries:   make(map[string]trackedEntry),
+		keys:      make(map[string]*trackedKey),
+		lastSweep: time.Now(),
+	}
+	opts := *client.Options()
+	onConnect := opts.OnConnect
+	opts.PoolSize = 1
+	opts.MinIdleConns = 0
+	opts.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
+		if onConnect != nil {
+			if err := onConnect(ctx, cn); err != nil {
+				return err
+			}
+		}
+		c.setListening(false)
+		id, err := cn.ClientID(ctx).Result()
+		if err != nil {
+			return err
+		}
+		args := []interface{}{"CLIENT", "TRACKING", "ON", "REDIRECT", id, "BCAST"}
+		for _, prefix := range prefixes {
+			args = append(args, "PREFIX", prefix)
+		}
+		return cn.Process(ctx, redis.NewStatusCmd(ctx, args...))
+	}
+	c.client = redis.NewClient(&opts)
+	c.pubsub = c.client.Subscribe(context.Background(), trackingInvalidateChannel)
+	go c.listen()
+	slog.Info("Caching queue lengths with Redis client tracking", "prefixes", prefixes, "maxAge", maxAge)
+	return c
+}
+
+// listen applies invalidations until the cache is closed. Entries are only cached once the
+// subscription is confirmed, since invalidations sent before it are lost.
+func (c *trackingCache) listen() {
+	for {
+		msg, err := c.pubsub.Receive(context.Background())
+		if err != nil {
+			if err == redis.ErrClosed {
+				return
+			}
+			// A flush invalidates with a null key list, which go-redis cannot parse, so
+			// every error drops the whole cache
+			if c.setListening(false) {
+				slog.Warn("Redis client tracking interrupted, dropped cached queue lengths", "error", err)
+			}
+			time.Sleep(time.Second)
+			continue
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind == "subscribe" {
+				c.setListening(true)
+			}
+		case *redis.Message:
+			if msg.PayloadSlice != nil {
+				c.invalidate(msg.PayloadSlice)
+			} else {
+				c.invalidate([]string{msg.Payload})
+			}
+		}
+	}
+}
+
+// setListening records whether invalidations are being received, dropping every entry
+// whenever that changes. It reports whether it did.
+func (c *trackingCache) setListening(listening bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.listening == listening {
+		return false
+	}
+	c.listening = listening
+	clear(c.entries)
+	clear(c.keys)
+	c.seq++
+	return true
+}
+
+// get returns the cached lengths for a key set. A nil cache, when client tracking is
+// disabled, never has any.
+func (c *trackingCache) get(id string) (queueLengths, bool) {
+	if c == nil {
+		return queueLengths{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[id]
+	if !ok || time.Since(entry.readAt) >= c.maxAge {
+		return queueLengths{}, false
+	}
+	return entry.lengths, true
+}
+
+// begin starts recording invalidations of keys for a read about to start, and returns the
+// sequence number to pass to put
+func (c *trackingCache) begin(keys []string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, key := range keys {
+		if _, ok := c.keys[key]; !ok {
+			c.keys[key] = &trackedKey{ids: make(map[string]struct{}), added: now}
+		}
+	}
+	return c.seq
+}
+
+// put caches lengths read from keys since begin returned seq, unless one of the keys was
+// invalidated meanwhile, which may or may not be reflected in the read. Keys dropped
+// meanwhile mean the cache was reset.
+func (c *trackingCache) put(id string, keys []string, lengths queueLengths, seq uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.listening {
+		return
+	}
+	for _, key := range keys {
+		if tracked, ok := c.keys[key]; !ok || tracked.invalidated > seq {
+			return
+		}
+	}
+	now := time.Now()
+	c.sweep(now)
+	for _, key := range keys {
+		c.keys[key].ids[id] = struct{}{}
+	}
+	c.entries[id] = trackedEntry{lengths: lengths, keys: keys, readAt: now}
+}
+
+// invalidate drops the entries read from any of keys
+func (c *trackingCache) invalidate(keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	for _, key := range keys {
+		tracked, ok := c.keys[key]
+		if !ok {
+			continue
+		}
+		tracked.invalidated = c.seq
+		for id := range tracked.ids {
+			c.remove(id)
+		}
+	}
+}
+
+// sweep drops entries older than maxAge, and keys of reads that failed, at most once per
+// maxAge. Callers must hold c.mu.
+func (c *trackingCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.maxAge {
+		return
+	}
+	c.lastSweep = now
+	for id, entry := range c.entries {
+		if now.Sub(entry.readAt) >= c.maxAge {
+			c.remove(id)
+		}
+	}
+	for key, tracked := range c.keys {
+		if len(tracked.ids) == 0 && now.Sub(tracked.added) >= c.maxAge {
+			delete(c.keys, key)
+		}
+	}
+}
+
+// remove drops an entry and forgets keys no other entry was read from. Callers must hold
+// c.mu.
+func (c *trackingCache) remove(id string) {
+	entry, ok := c.entries[id]
+	if !ok {
+		return
+	}
+	delete(c.entries, id)
+	for _, key := range entry.keys {
+		if tracked, ok := c.keys[key]; ok {
+			delete(tracked.ids, id)
+			if len(tracked.ids) == 0 {
+				delete(c.keys, key)
+			}
+		}
+	}
+}
+
+// Close stops listening for invalidations, for use during shutdown
+func (c *trackingCache) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.pubsub.Close()
+	return c.client.Close()
+}
+
+// trackedKeys returns the keys a queue length read depends on, or nil when the counts
+// also depend on something Redis cannot report changes of: the clock for delayedDue and
+// scheduleLookahead, keys yet to match waitListPattern, and group keys found while
+// counting. Key sets on other Redis instances cannot be tracked either.
+func (k queueKeys) trackedKeys() []string {
+	if k.redis.host != "" || k.waitPattern != "" || k.delayedDue || k.lookahead > 0 || len(k.groups) > 0 {
+		return nil
+	}
+	keys := slices.Concat(k.wait, k.active, k.delayed, k.prioritized, k.stalled, k.waitingChildren, k.completed, k.failed)
+	if k.paused != "" {
+		keys = append(keys, k.paused)
+	}
+	return keys
+}
+
+// clientTrackingPrefixes returns the key prefixes to track from
+// REDIS_CLIENT_TRACKING_PREFIXES, by default every key under the default queuePrefix
+func clientTrackingPrefixes() []string {
+	prefix := defaultQueuePrefix
+	if defaults := metadataDefaults.Load(); defaults != nil && (*defaults)["queuePrefix"] != "" {
+		prefix = (*defaults)["queuePrefix"]
+	}
+	return parseKeyList(getEnvDefault("REDIS_CLIENT_TRACKING_PREFIXES", prefix+":"))
+}
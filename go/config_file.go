@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the layout of the optional CONFIG_FILE. YAML is a superset of JSON, so the
+// same struct reads both formats.
+type fileConfig struct {
+	Redis    fileRedisConfig    `yaml:"redis"`
+	Defaults fileMetadataConfig `yaml:"defaults"`
+}
+
+// fileRedisConfig holds Redis connection settings, each mapping to the env var noted
+type fileRedisConfig struct {
+	Host           string   `yaml:"host"`           // REDIS_HOST
+	Port           string   `yaml:"port"`           // REDIS_PORT
+	DB             string   `yaml:"db"`             // REDIS_DB
+	Username       string   `yaml:"username"`       // REDIS_USERNAME
+	Password       string   `yaml:"password"`       // REDIS_PASSWORD
+	ClusterEnabled string   `yaml:"clusterEnabled"` // REDIS_CLUSTER_ENABLED
+	ClusterAddrs   []string `yaml:"clusterAddrs"`   // REDIS_CLUSTER_ADDRS
+	SentinelAddrs  []string `yaml:"sentinelAddrs"`  // REDIS_SENTINEL_ADDRS
+	MasterName     string   `yaml:"masterName"`     // REDIS_MASTER_NAME
+	OpTimeout      string   `yaml:"opTimeout"`      // REDIS_OP_TIMEOUT
+	MaxRetries     string   `yaml:"maxRetries"`     // REDIS_MAX_RETRIES
+	PoolSize       string   `yaml:"poolSize"`       // REDIS_POOL_SIZE
+	DialTimeout    string   `yaml:"dialTimeout"`    // REDIS_DIAL_TIMEOUT
+	ReadTimeout    string   `yaml:"readTimeout"`    // REDIS_READ_TIMEOUT
+	WriteTimeout   string   `yaml:"writeTimeout"`   // REDIS_WRITE_TIMEOUT
+}
+
+// fileMetadataConfig holds trigger metadata defaults for ScaledObjects that omit the key
+type fileMetadataConfig struct {
+	TargetSize          *int64 `yaml:"targetSize"`
+	MaxPods             *int64 `yaml:"maxPods"`
+	ActivationThreshold *int64 `yaml:"activationThreshold"`
+	QueuePrefix         string `yaml:"queuePrefix"`
+}
+
+// metadataDefaults holds trigger metadata values from the DEFAULT_* env vars and
+// CONFIG_FILE. It is set at startup, before the gRPC server starts, and replaced whenever
+// the file is reloaded.
+var metadataDefaults atomic.Pointer[map[string]string]
+
+// envMetadataDefaults holds the trigger metadata defaults from DEFAULT_* env vars. They
+// override the file's defaults, as env vars override the rest of the file.
+var envMetadataDefaults map[string]string
+
+// loadEnvMetadataDefaults reads the DEFAULT_* env vars and fails fast on an invalid value
+func loadEnvMetadataDefaults() {
+	envMetadataDefaults = make(map[string]string)
+	setDefault := func(env, key string, minimum int64) {
+		value := os.Getenv(env)
+		if value == "" {
+			return
+		}
+		if parsed, err := strconv.ParseInt(value, 10, 64); err != nil || parsed < minimum {
+			fatal("Invalid env var: must be an integer of at least "+strconv.FormatInt(minimum, 10), "key", env, "value", value)
+		}
+		envMetadataDefaults[key] = value
+	}
+	setDefault("DEFAULT_TARGET_QUEUE_LENGTH", "targetSize", 1)
+	setDefault("DEFAULT_MAX_PODS", "maxPods", 0)
+	setDefault("DEFAULT_ACTIVATION_THRESHOLD", "activationThreshold", 0)
+	if prefix := os.Getenv("DEFAULT_QUEUE_PREFIX"); prefix != "" {
+		envMetadataDefaults["queuePrefix"] = prefix
+	}
+	if len(envMetadataDefaults) > 0 {
+		slog.Info("Loaded metadata defaults from env", "metadataDefaults", envMetadataDefaults)
+	}
+}
+
+// storeMetadataDefaults publishes the file's defaults overlaid with the env defaults
+func storeMetadataDefaults(fileDefaults map[string]string) map[string]string {
+	defaults := make(map[string]string, len(fileDefaults)+len(envMetadataDefaults))
+	for key, value := range fileDefaults {
+		defaults[key] = value
+	}
+	for key, value := range envMetadataDefaults {
+		defaults[key] = value
+	}
+	metadataDefaults.Store(&defaults)
+	return defaults
+}
+
+// loadConfigFile loads the DEFAULT_* env vars, then reads the file named by CONFIG_FILE, if
+// set, and fails fast when it is missing or malformed. Redis settings from the file fill in
+// env vars that are unset, so the env keeps precedence and the rest of the startup code
+// reads them unchanged.
+func loadConfigFile() {
+	loadEnvMetadataDefaults()
+	storeMetadataDefaults(nil)
+
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return
+	}
+
+	cfg, err := parseConfigFile(path)
+	if err != nil {
+		fatal("Invalid CONFIG_FILE", "path", path, "error", err)
+	}
+
+	var applied []string
+	setDefault := func(key, value string) {
+		if value == "" || os.Getenv(key) != "" {
+			return
+		}
+		os.Setenv(key, value)
+		applied = append(applied, key)
+	}
+	setDefault("REDIS_HOST", cfg.Redis.Host)
+	setDefault("REDIS_PORT", cfg.Redis.Port)
+	setDefault("REDIS_DB", cfg.Redis.DB)
+	setDefault("REDIS_USERNAME", cfg.Redis.Username)
+	setDefault("REDIS_PASSWORD", cfg.Redis.Password)
+	setDefault("REDIS_CLUSTER_ENABLED", cfg.Redis.ClusterEnabled)
+	setDefault("REDIS_CLUSTER_ADDRS", strings.Join(cfg.Redis.ClusterAddrs, ","))
+	setDefault("REDIS_SENTINEL_ADDRS", strings.Join(cfg.Redis.SentinelAddrs, ","))
+	setDefault("REDIS_MASTER_NAME", cfg.Redis.MasterName)
+	setDefault("REDIS_OP_TIMEOUT", cfg.Redis.OpTimeout)
+	setDefault("REDIS_MAX_RETRIES", cfg.Redis.MaxRetries)
+	setDefault("REDIS_POOL_SIZE", cfg.Redis.PoolSize)
+	setDefault("REDIS_DIAL_TIMEOUT", cfg.Redis.DialTimeout)
+	setDefault("REDIS_READ_TIMEOUT", cfg.Redis.ReadTimeout)
+	setDefault("REDIS_WRITE_TIMEOUT", cfg.Redis.WriteTimeout)
+
+	defaults := storeMetadataDefaults(cfg.Defaults.metadata())
+	slog.Info("Loaded config file", "path", path, "envDefaults", applied, "metadataDefaults", defaults)
+
+	if interval := getEnvDuration("CONFIG_RELOAD_INTERVAL", 30*time.Second); interval > 0 {
+		go watchConfigFile(path, cfg, interval)
+	}
+}
+
+// metadata returns the defaults as trigger metadata values
+func (d fileMetadataConfig) metadata() map[string]string {
+	defaults := make(map[string]string)
+	setMetadataDefault := func(key string, value *int64) {
+		if value != nil {
+			defaults[key] = strconv.FormatInt(*value, 10)
+		}
+	}
+	setMetadataDefault("targetSize", d.TargetSize)
+	setMetadataDefault("maxPods", d.MaxPods)
+	setMetadataDefault("activationThreshold", d.ActivationThreshold)
+	if d.QueuePrefix != "" {
+		defaults["queuePrefix"] = d.QueuePrefix
+	}
+	return defaults
+}
+
+// watchConfigFile re-reads the config file every interval once its modification time
+// changes, and swaps in its metadata defaults, so a changed ConfigMap reaches every
+// ScaledObject without a restart. Polling the modification time also catches the symlink
+// swap Kubernetes uses to update mounted ConfigMaps. An invalid file is logged and the
+// previous defaults kept. Redis settings are only read at startup, so changing them
+// logs a warning instead.
+func watchConfigFile(path string, loaded fileConfig, interval time.Duration) {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = info.ModTime()
+
+		cfg, err := parseConfigFile(path)
+		if err != nil {
+			slog.Warn("Invalid CONFIG_FILE after change; keeping the previous settings", "path", path, "error", err)
+			continue
+		}
+		if !reflect.DeepEqual(cfg.Redis, loaded.Redis) {
+			slog.Warn("CONFIG_FILE redis settings changed; restart the scaler to apply them", "path", path)
+		}
+		defaults := storeMetadataDefaults(cfg.Defaults.metadata())
+		slog.Info("Reloaded config file", "path", path, "metadataDefaults", defaults)
+	}
+}
+
+// parseConfigFile decodes and validates a config file. Unknown fields are rejected so a
+// misspelled setting is reported with its line number instead of being silently ignored.
+func parseConfigFile(path string) (fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fileConfig{}, err
+	}
+
+	var cfg fileConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fileConfig{}, fmt.Errorf("file is empty")
+		}
+		return fileConfig{}, err
+	}
+
+	d := cfg.Defaults
+	if d.TargetSize != nil && *d.TargetSize <= 0 {
+		return fileConfig{}, fmt.Errorf("defaults.targetSize must be a positive integer, got: %d", *d.TargetSize)
+	}
+	if d.MaxPods != nil && *d.MaxPods < 0 {
+		return fileConfig{}, fmt.Errorf("defaults.maxPods must be a non-negative integer (0 disables the cap), got: %d", *d.MaxPods)
+	}
+	if d.ActivationThreshold != nil && *d.ActivationThreshold < 0 {
+		return fileConfig{}, fmt.Errorf("defaults.activationThreshold must be a non-negative integer, got: %d", *d.ActivationThreshold)
+	}
+	return cfg, nil
+}
+
+// withMetadataDefaults returns the trigger metadata with CONFIG_FILE defaults filled in for
+// keys the ScaledObject omits or leaves empty, under any alias. The caller's map is never
+// modified.
+func withMetadataDefaults(metadata map[string]string) map[string]string {
+	defaults := metadataDefaults.Load()
+	if defaults == nil || len(*defaults) == 0 {
+		return metadata
+	}
+	merged := make(map[string]string, len(metadata)+len(*defaults))
+	for key, value := range *defaults {
+		if set, _ := metadataKey(metadata, key); metadata[set] == "" {
+			merged[key] = value
+		}
+	}
+	for key, value := range metadata {
+		if value != "" {
+			merged[key] = value
+		}
+	}
+	return merged
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// debugRequestTimeout bounds the Redis reads of one debug API request
+const debugRequestTimeout = 10 * time.Second
+
+// startDebugServer serves the HTTP debug API on DEBUG_PORT in the background, so the
+// scaler can be queried with curl during an incident without grpcurl or the proto file. It
+// is off unless the port is set, since it reads any queue it is asked about. A failure to
+// bind is logged as a warning so it never takes down the scaler.
+func startDebugServer(port string, s *server) {
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		slog.Warn("Failed to start debug server", "port", port, "error", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/metrics", s.handleDebugMetrics)
+	mux.HandleFunc("GET /debug/state", s.handleDebugState)
+
+	go func() {
+		slog.Info("Serving debug API (/v1/metrics, /debug/state)", "port", port)
+		if err := http.Serve(lis, mux); err != nil {
+			slog.Warn("Debug server stopped", "error", err)
+		}
+	}()
+}
+
+// handleDebugMetrics runs IsActive, GetMetricSpec and GetMetrics once, like the check
+// subcommand, and returns the report as JSON. namespace and name identify the
+// ScaledObject, queue is shorthand for queueName, and every other query parameter is
+// passed on as trigger metadata, e.g. /v1/metrics?name=emails&queue=emails&targetSize=5.
+func (s *server) handleDebugMetrics(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	ref := &pb.ScaledObjectRef{
+		Name:           query.Get("name"),
+		Namespace:      query.Get("namespace"),
+		ScalerMetadata: make(map[string]string),
+	}
+	for key, values := range query {
+		switch key {
+		case "name", "namespace":
+		case "queue":
+			ref.ScalerMetadata["queueName"] = values[0]
+		default:
+			ref.ScalerMetadata[key] = values[0]
+		}
+	}
+	if ref.Name == "" {
+		ref.Name = "debug"
+	}
+	if ref.Namespace == "" {
+		ref.Namespace = "debug"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), debugRequestTimeout)
+	defer cancel()
+
+	report, err := s.check(ctx, ref)
+	code := http.StatusOK
+	if err != nil {
+		report.Error = err.Error()
+		code = http.StatusBadGateway
+		if status.Code(err) == codes.InvalidArgument {
+			code = http.StatusBadRequest
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleDebugState lists the ScaledObjects served recently with their queue, last results
+// and last error, to see at a glance which are served and which are failing
+func (s *server) handleDebugState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"triggers": s.triggers.snapshot()})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+)
+
+// drainConfig is the targetDrainSeconds metadata: report the pods needed to clear the
+// backlog within seconds, at jobsPerPod jobs per second each, instead of the backlog
+// itself
+type drainConfig struct {
+	seconds    int64 // 0 when drain-time scaling is off
+	jobsPerPod float64
+	// measure replaces jobsPerPod with the throughput measured from the finished job
+	// counts once one is available; concurrency is the jobs each pod runs at once
+	measure     bool
+	concurrency int64
+}
+
+// parseDrainTime reads targetDrainSeconds, jobsPerPodPerSecond, measureThroughput and
+// workerConcurrency. The metric is then a pod count, so its target is always 1.
+func (m *scalerMetadata) parseDrainTime(metadata map[string]string, targetSet, keysResolved bool) error {
+	var err error
+	if m.drain.seconds, err = getMetadataNonNegativeInt(metadata, "targetDrainSeconds", 0); err != nil {
+		return err
+	}
+	if m.drain.seconds == 0 {
+		for _, key := range []string{"jobsPerPodPerSecond", "measureThroughput", "workerConcurrency"} {
+			if metadata[key] != "" {
+				return fmt.Errorf("%s requires targetDrainSeconds", key)
+			}
+		}
+		return nil
+	}
+	if m.drain.jobsPerPod, err = getMetadataNonNegativeFloat(metadata, "jobsPerPodPerSecond", 0); err != nil {
+		return err
+	}
+	if m.drain.jobsPerPod == 0 {
+		return fmt.Errorf("targetDrainSeconds requires a positive jobsPerPodPerSecond")
+	}
+	if m.drain.measure, err = getMetadataBool(metadata, "measureThroughput", false); err != nil {
+		return err
+	}
+	if m.drain.concurrency, err = getMetadataPositiveInt(metadata, "workerConcurrency", 1); err != nil {
+		return err
+	}
+	if m.drain.measure && keysResolved && len(m.keys.completed) == 0 && len(m.keys.failed) == 0 {
+		return fmt.Errorf("measureThroughput requires the completedSet or failedSet metadata key")
+	}
+	if targetSet {
+		return fmt.Errorf("targetDrainSeconds reports pods with a target of 1, so it cannot be combined with targetSize")
+	}
+	if m.scaleOnAge {
+		return fmt.Errorf("targetDrainSeconds cannot be combined with scaleOn oldestJobAge")
+	}
+	m.targetSize = 1
+	return nil
+}
+
+// drainPods converts a backlog into the pods that clear it within targetDrainSeconds. With
+// measureThroughput, a pod's throughput is the rate jobs finished at, spread over the pods
+// that ran them: the active jobs divided by workerConcurrency. The configured
+// jobsPerPodPerSecond stands in until a rate is measured and while nothing runs.
+func (s *server) drainPods(ref *pb.ScaledObjectRef, meta scalerMetadata, lengths queueLengths, backlog int64, logger *slog.Logger) int64 {
+	perPod := meta.drain.jobsPerPod
+	if meta.drain.measure && lengths.finishedErr == nil {
+		rate, ok := s.queueStates.completionRate(ref.Namespace+"/"+ref.Name+"\x00drain", lengths.finished, time.Now(), meta.completionWindow)
+		if busyPods := float64(lengths.active) / float64(meta.drain.concurrency); ok && rate > 0 && busyPods > 0 {
+			perPod = rate / 60 / busyPods
+		}
+	}
+	pods := int64(math.Ceil(float64(backlog) / (perPod * float64(meta.drain.seconds))))
+	logger.Debug("Converted backlog to pods for targetDrainSeconds", "backlog", backlog, "jobsPerPodPerSecond", perPod,
+		"targetDrainSeconds", meta.drain.seconds, "pods", pods)
+	return pods
+}
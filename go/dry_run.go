@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+)
+
+// startDryRun starts the observe-only loop when DRY_RUN is enabled. It builds trigger
+// metadata from DRY_RUN_* env vars and logs the IsActive result and metric value every
+// DRY_RUN_INTERVAL, using the same code paths as the RPCs, so key names and thresholds can
+// be checked against real data before a ScaledObject is wired up.
+func startDryRun(s *server) {
+	if !getEnvBool("DRY_RUN", false) {
+		return
+	}
+
+	ref := &pb.ScaledObjectRef{
+		Name:      "dry-run",
+		Namespace: "dry-run",
+		ScalerMetadata: map[string]string{
+			"waitList":   getEnv("DRY_RUN_WAIT_LIST"),
+			"activeList": getEnv("DRY_RUN_ACTIVE_LIST"),
+			"maxPods":    os.Getenv("DRY_RUN_MAX_PODS"),
+		},
+	}
+	if _, err := validateScalerMetadata(ref.Namespace, ref.ScalerMetadata); err != nil {
+		fatal("Invalid DRY_RUN configuration", "error", err)
+	}
+
+	interval := getEnvDuration("DRY_RUN_INTERVAL", 10*time.Second)
+	if interval <= 0 {
+		fatal("Invalid DRY_RUN_INTERVAL: must be greater than zero")
+	}
+
+	logger := requestLogger(context.Background(), "DryRun", ref)
+	started := s.background.tryGo("dry run", func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.observeOnce(logger, ref)
+			<-ticker.C
+		}
+	})
+	if started {
+		slog.Info("Dry-run mode enabled", "interval", interval, "waitList", ref.ScalerMetadata["waitList"],
+			"activeList", ref.ScalerMetadata["activeList"], "maxPods", ref.ScalerMetadata["maxPods"])
+	}
+}
+
+// observeOnce computes and logs what the scaler would answer for ref right now
+func (s *server) observeOnce(logger *slog.Logger, ref *pb.ScaledObjectRef) {
+	ctx := context.Background()
+
+	active, err := s.checkActive(ctx, ref, logger)
+	if err != nil {
+		logger.Warn("Dry run failed", "error", err)
+		return
+	}
+	metricValues, err := s.computeMetrics(ctx, ref, logger)
+	if err != nil {
+		logger.Warn("Dry run failed", "error", err)
+		return
+	}
+	logger.Info("Dry-run decision", "isActive", active, "metricValue", metricValues[0].MetricValueFloat)
+}
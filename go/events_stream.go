@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// eventsConfig is the scaleOn eventsBacklog metadata: the BullMQ events streams to measure,
+// and the consumer group reading them, "" to measure their length
+type eventsConfig struct {
+	streams []string
+	group   string
+}
+
+// parseEventsStream reads eventsStream and eventsConsumerGroup. BullMQ writes the events of
+// a queue to the stream next to its wait list, "bull:q:events" for "bull:q:wait", which is
+// the default.
+func (m *scalerMetadata) parseEventsStream(metadata map[string]string, keysResolved bool) error {
+	streams := parseKeyList(metadata["eventsStream"])
+	group := metadata["eventsConsumerGroup"]
+	if !m.scaleOnEvents {
+		if len(streams) > 0 || group != "" {
+			return fmt.Errorf("eventsStream and eventsConsumerGroup require scaleOn eventsBacklog")
+		}
+		return nil
+	}
+	if len(streams) == 0 && keysResolved {
+		for _, waitList := range m.keys.wait {
+			streams = append(streams, jobKeyPrefix(waitList)+"events")
+		}
+		if len(streams) == 0 {
+			return fmt.Errorf("scaleOn eventsBacklog requires eventsStream when no waitList or queueName is set")
+		}
+	}
+	m.events = eventsConfig{streams: streams, group: group}
+	return nil
+}
+
+// readEventsBacklog returns the events not yet processed across the streams. With a
+// consumer group, that is the entries delivered but not acknowledged plus those not
+// delivered yet, the group's lag. Redis reports the lag from 7.0 on, and only while it
+// can tell, e.g. not after entries were deleted from the middle of the stream; without it
+// the whole stream counts, since scaling out too far beats leaving events unprocessed.
+// Without a group it is the stream length. A missing stream or group counts as 0.
+func (s *server) readEventsBacklog(ctx context.Context, client redisCmdable, cfg eventsConfig) (int64, error) {
+	var backlog int64
+	for _, stream := range cfg.streams {
+		if cfg.group == "" {
+			var length int64
+			err := s.redisOp(ctx, "xlen", func(ctx context.Context) (err error) {
+				length, err = client.XLen(ctx, stream).Result()
+				return err
+			})
+			if err != nil {
+				return 0, s.keyReadError("eventsStream", stream, "stream", err)
+			}
+			backlog += length
+			continue
+		}
+
+		pending, lag, found, err := s.readGroupBacklog(ctx, client, stream, cfg.group)
+		if err != nil {
+			return 0, err
+		}
+		if !found {
+			slog.Debug("Consumer group not found on events stream, counting 0", "eventsStream", stream, "eventsConsumerGroup", cfg.group)
+			continue
+		}
+		if lag < 0 {
+			err := s.redisOp(ctx, "xlen", func(ctx context.Context) (err error) {
+				lag, err = client.XLen(ctx, stream).Result()
+				return err
+			})
+			if err != nil {
+				return 0, s.keyReadError("eventsStream", stream, "stream", err)
+			}
+			slog.Debug("Consumer group lag unknown, counting the whole events stream", "eventsStream", stream, "length", lag)
+		}
+		backlog += pending + lag
+	}
+	return backlog, nil
+}
+
+// readGroupBacklog reads the pending count and lag of a consumer group from XINFO GROUPS,
+// which go-redis v8 does not parse the lag of. lag is -1 when Redis does not report it.
+func (s *server) readGroupBacklog(ctx context.Context, client redisCmdable, stream, group string) (pending, lag int64, found bool, err error) {
+	var groups []interface{}
+	err = s.redisOp(ctx, "xinfo_groups", func(ctx context.Context) (err error) {
+		groups, err = client.Do(ctx, "XINFO", "GROUPS", stream).Slice()
+		return err
+	})
+	if err != nil {
+		// XINFO fails with "ERR no such key" on a stream that does not exist yet
+		if isRedisReplyError(err) && strings.Contains(err.Error(), "no such key") {
+			return 0, 0, false, nil
+		}
+		return 0, 0, false, s.keyReadError("eventsStream", stream, "stream", err)
+	}
+	for _, entry := range groups {
+		fields, ok := entry.([]interface{})
+		if !ok {
+			continue
+		}
+		info := make(map[string]interface{}, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			if name, ok := fields[i].(string); ok {
+				info[name] = fields[i+1]
+			}
+		}
+		if info["name"] != group {
+			continue
+		}
+		pending, _ = info["pending"].(int64)
+		lag = -1
+		if value, ok := info["lag"].(int64); ok {
+			lag = value
+		}
+		return pending, lag, true, nil
+	}
+	return 0, 0, false, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log/slog"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// goroutineLimiter bounds the number of goroutines spawned by streaming and background
+// features so their count cannot grow without limit as ScaledObjects are added
+type goroutineLimiter struct {
+	slots chan struct{}
+}
+
+// newGoroutineLimiter creates a limiter allowing at most max concurrent background goroutines
+func newGoroutineLimiter(max int) *goroutineLimiter {
+	return &goroutineLimiter{slots: make(chan struct{}, max)}
+}
+
+// tryAcquire reserves a slot for a long-running task and reports whether one was free.
+// A successful call must be paired with release.
+func (l *goroutineLimiter) tryAcquire(name string) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		slog.Warn("Background goroutine limit reached", "limit", cap(l.slots), "task", name)
+		return false
+	}
+}
+
+// release frees a slot reserved by tryAcquire
+func (l *goroutineLimiter) release() {
+	<-l.slots
+}
+
+// tryGo runs fn in a new goroutine if a slot is free and reports whether it did. Callers
+// must degrade gracefully (for example by falling back to polling) when it returns false.
+func (l *goroutineLimiter) tryGo(name string, fn func()) bool {
+	if !l.tryAcquire(name) {
+		return false
+	}
+
+	go func() {
+		defer l.release()
+		fn()
+	}()
+	return true
+}
+
+// inUse returns the number of background goroutines currently running
+func (l *goroutineLimiter) inUse() int {
+	return len(l.slots)
+}
+
+// registerGoroutineMetrics exports the process goroutine count and the limiter's usage
+func registerGoroutineMetrics(l *goroutineLimiter) {
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "scaler_goroutines",
+			Help: "Number of goroutines currently running in the scaler process.",
+		}, func() float64 { return float64(runtime.NumGoroutine()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "scaler_background_goroutines",
+			Help: "Number of bounded background goroutines currently running.",
+		}, func() float64 { return float64(l.inUse()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "scaler_background_goroutines_limit",
+			Help: "Maximum number of bounded background goroutines.",
+		}, func() float64 { return float64(cap(l.slots)) }),
+	)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// readGroupedWaitLength sums the waiting jobs of BullMQ Pro groups. Pro keeps grouped jobs
+// out of the wait list: the "groups" sorted set holds the IDs of groups with jobs to run,
+// and each group's jobs wait in "<groups key>:<id>", a list, or a sorted set for groups
+// with prioritized jobs. With groupConcurrency each group counts at most that many jobs,
+// since no more of them can run at once however many workers there are. The group IDs are
+// capped at SCAN_MAX_KEYS and their lengths pipelined scanCount keys at a time.
+func (s *server) readGroupedWaitLength(ctx context.Context, client redisCmdable, keys queueKeys, groupsKey string) (int64, error) {
+	var ids []string
+	err := s.redisOp(ctx, "zrange", func(ctx context.Context) (err error) {
+		ids, err = client.ZRange(ctx, groupsKey, 0, int64(s.scanMaxKeys)).Result()
+		return err
+	})
+	if err != nil {
+		return 0, s.keyReadError("groupsKey", groupsKey, "sorted set", err)
+	}
+	if len(ids) > s.scanMaxKeys {
+		slog.Warn("Queue has more groups than SCAN_MAX_KEYS; the total only counts the first ones",
+			"groupsKey", groupsKey, "maxKeys", s.scanMaxKeys)
+		ids = ids[:s.scanMaxKeys]
+	}
+
+	var total int64
+	for start := 0; start < len(ids); start += int(s.scanCount) {
+		batch := ids[start:min(start+int(s.scanCount), len(ids))]
+
+		var cmds []*redis.IntCmd
+		pipeErr := s.redisOp(ctx, "groups_pipeline", func(ctx context.Context) error {
+			pipe := client.Pipeline()
+			for _, id := range batch {
+				cmds = append(cmds, pipe.LLen(ctx, groupsKey+":"+id))
+			}
+			_, err := pipe.Exec(ctx)
+			return err
+		})
+		if pipeErr != nil && !isRedisReplyError(pipeErr) {
+			return 0, fmt.Errorf("failed to read groups of '%s': %w", groupsKey, pipeErr)
+		}
+		for i, cmd := range cmds {
+			count, err := cmd.Result()
+			if err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE") {
+				err = s.redisOp(ctx, "zcard", func(ctx context.Context) (err error) {
+					count, err = client.ZCard(ctx, groupsKey+":"+batch[i]).Result()
+					return err
+				})
+			}
+			if err != nil {
+				return 0, s.keyReadError("group", groupsKey+":"+batch[i], "list or sorted set", err)
+			}
+			if keys.groupConcurrency > 0 {
+				count = min(count, keys.groupConcurrency)
+			}
+			total += count
+		}
+	}
+
+	slog.Debug("Summed waiting jobs of groups", "groupsKey", groupsKey, "groups", len(ids), "groupedLen", total)
+	return total, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// keepaliveOptions returns the keepalive and connection limits of the gRPC server. KEDA
+// keeps one long-lived connection to the scaler, so after a network blip a half-open
+// connection can stall scaling until KEDA restarts; pinging idle connections every
+// GRPC_KEEPALIVE_TIME closes them within GRPC_KEEPALIVE_TIMEOUT instead. The enforcement
+// policy lets clients ping as often as GRPC_KEEPALIVE_MIN_TIME, even without active calls,
+// so a client with its own keepalive is not disconnected for pinging.
+func keepaliveOptions() []grpc.ServerOption {
+	params := keepalive.ServerParameters{
+		Time:                  getEnvDuration("GRPC_KEEPALIVE_TIME", 30*time.Second),
+		Timeout:               getEnvDuration("GRPC_KEEPALIVE_TIMEOUT", 10*time.Second),
+		MaxConnectionIdle:     getEnvDuration("GRPC_MAX_CONNECTION_IDLE", 0),
+		MaxConnectionAge:      getEnvDuration("GRPC_MAX_CONNECTION_AGE", 0),
+		MaxConnectionAgeGrace: getEnvDuration("GRPC_MAX_CONNECTION_AGE_GRACE", 0),
+	}
+	if params.Time <= 0 || params.Timeout <= 0 {
+		fatal("Invalid GRPC_KEEPALIVE_TIME or GRPC_KEEPALIVE_TIMEOUT: must be greater than zero")
+	}
+	policy := keepalive.EnforcementPolicy{
+		MinTime:             getEnvDuration("GRPC_KEEPALIVE_MIN_TIME", 10*time.Second),
+		PermitWithoutStream: getEnvBool("GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM", true),
+	}
+
+	options := []grpc.ServerOption{grpc.KeepaliveParams(params), grpc.KeepaliveEnforcementPolicy(policy)}
+	maxStreams, err := strconv.ParseUint(getEnvDefault("GRPC_MAX_CONCURRENT_STREAMS", "0"), 10, 32)
+	if err != nil {
+		fatal("Invalid GRPC_MAX_CONCURRENT_STREAMS: must be a non-negative integer")
+	}
+	if maxStreams > 0 {
+		options = append(options, grpc.MaxConcurrentStreams(uint32(maxStreams)))
+	}
+
+	slog.Info("gRPC keepalive configured", "time", params.Time, "timeout", params.Timeout,
+		"minClientPingInterval", policy.MinTime, "maxConnectionAge", params.MaxConnectionAge,
+		"maxConnectionIdle", params.MaxConnectionIdle, "maxConcurrentStreams", maxStreams)
+	return options
+}
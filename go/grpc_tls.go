@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// grpcServerOptions returns the options for the KEDA-facing gRPC server. When
+// GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE are set the server uses TLS, and
+// GRPC_TLS_CLIENT_CA_FILE additionally requires client certificates signed by that CA
+// (mTLS). Without them the server stays plaintext.
+func grpcServerOptions() []grpc.ServerOption {
+	certFile := os.Getenv("GRPC_TLS_CERT_FILE")
+	keyFile := os.Getenv("GRPC_TLS_KEY_FILE")
+	clientCAFile := os.Getenv("GRPC_TLS_CLIENT_CA_FILE")
+
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			fatal("GRPC_TLS_CLIENT_CA_FILE requires GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE")
+		}
+		return nil
+	}
+	if certFile == "" || keyFile == "" {
+		fatal("GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE must be set together")
+	}
+
+	reloader := &tlsReloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := reloader.load(); err != nil {
+		fatal("Failed to load gRPC TLS files", "error", err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: reloader.configForClient,
+	}
+
+	slog.Info("gRPC TLS enabled", "certFile", certFile, "mTLS", clientCAFile != "")
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsConfig))}
+}
+
+// tlsReloadCheck bounds how often handshakes stat the TLS files
+const tlsReloadCheck = 10 * time.Second
+
+// tlsReloader serves the gRPC TLS config from the certificate, key and client CA files,
+// reloading them when their modification times change. cert-manager and mounted secrets
+// rotate the files in place, so new connections pick up the new certificate without a
+// restart. A failed reload keeps the previous config and logs a warning.
+type tlsReloader struct {
+	certFile, keyFile, clientCAFile string
+
+	mu          sync.Mutex
+	config      *tls.Config
+	modTimes    []time.Time
+	lastChecked time.Time
+}
+
+// configForClient is the tls.Config GetConfigForClient hook
+func (r *tlsReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := time.Now(); now.Sub(r.lastChecked) >= tlsReloadCheck {
+		r.lastChecked = now
+		if modTimes := r.modTimesNow(); !slices.EqualFunc(modTimes, r.modTimes, time.Time.Equal) {
+			if err := r.loadLocked(); err != nil {
+				slog.Warn("Failed to reload gRPC TLS files, keeping the previous certificate", "error", err)
+			} else {
+				slog.Info("Reloaded gRPC TLS files", "certFile", r.certFile)
+			}
+		}
+	}
+	return r.config, nil
+}
+
+// load reads the TLS files and builds the config served to clients
+func (r *tlsReloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadLocked()
+}
+
+// loadLocked is load for callers that hold r.mu
+func (r *tlsReloader) loadLocked() error {
+	modTimes := r.modTimesNow()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate %s and key %s: %w", r.certFile, r.keyFile, err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("reading client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("parsing client CA %s: no PEM certificates found", r.clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.config = config
+	r.modTimes = modTimes
+	return nil
+}
+
+// modTimesNow returns the modification time of each TLS file, zero for unreadable ones
+func (r *tlsReloader) modTimesNow() []time.Time {
+	var modTimes []time.Time
+	for _, file := range []string{r.certFile, r.keyFile, r.clientCAFile} {
+		var modTime time.Time
+		if file != "" {
+			if info, err := os.Stat(file); err == nil {
+				modTime = info.ModTime()
+			}
+		}
+		modTimes = append(modTimes, modTime)
+	}
+	return modTimes
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// readinessPingTimeout bounds the Redis Ping and permission check made by /readyz
+const readinessPingTimeout = 2 * time.Second
+
+// shuttingDown is set once shutdown begins, so /readyz fails and the pod is taken out of
+// the Service endpoints while in-flight RPCs drain
+var shuttingDown atomic.Bool
+
+// startHealthServer serves Kubernetes liveness (/healthz) and readiness (/readyz) probes,
+// and the build information on /version, in the background. A failure to bind is logged as a warning so it never takes down the scaler.
+func startHealthServer(port string, ready func(ctx context.Context) error) {
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		slog.Warn("Failed to start health server", "port", port, "error", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/version", versionHandler)
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if shuttingDown.Load() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), readinessPingTimeout)
+		defer cancel()
+
+		if err := ready(ctx); err != nil {
+			slog.Warn("Readiness check failed", "error", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	})
+
+	go func() {
+		slog.Info("Serving health probes (/healthz, /readyz) and /version", "port", port)
+		if err := http.Serve(lis, mux); err != nil {
+			slog.Warn("Health server stopped", "error", err)
+		}
+	}()
+}
+
+// registerGRPCHealth registers the grpc.health.v1.Health service, so KEDA and gRPC probes
+// can check the scaler without a separate HTTP port. Both the overall status ("") and the
+// ExternalScaler service follow ready, Redis reachability and permissions, re-checked
+// every interval. The returned server is shut down on exit so in-flight checks report NOT_SERVING.
+func registerGRPCHealth(grpcServer *grpc.Server, ready func(ctx context.Context) error, interval time.Duration) *health.Server {
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	setStatus := func(status healthpb.HealthCheckResponse_ServingStatus) {
+		healthServer.SetServingStatus("", status)
+		healthServer.SetServingStatus(pb.ExternalScaler_ServiceDesc.ServiceName, status)
+	}
+	check := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), readinessPingTimeout)
+		defer cancel()
+		if err := ready(ctx); err != nil {
+			slog.Warn("gRPC health check failed", "error", err)
+			setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+			return
+		}
+		setStatus(healthpb.HealthCheckResponse_SERVING)
+	}
+
+	check()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			check()
+		}
+	}()
+	return healthServer
+}
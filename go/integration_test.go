@@ -0,0 +1,370 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/testcontainers/testcontainers-go"
+	tcredis "github.com/testcontainers/testcontainers-go/modules/redis"
+	"github.com/testcontainers/testcontainers-go/wait"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+)
+
+// The integration suite runs the scaler against real Redis servers started with
+// testcontainers, so it needs Docker:
+//
+//	go test -tags integration ./...
+//
+// REDIS_IMAGE picks the image, redis:7-alpine by default.
+
+func redisImage() string {
+	return getEnvDefault("REDIS_IMAGE", "redis:7-alpine")
+}
+
+// startRedis starts a standalone Redis and returns a client for it
+func startRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	ctx := context.Background()
+	ctr, err := tcredis.Run(ctx, redisImage())
+	testcontainers.CleanupContainer(t, ctr)
+	if err != nil {
+		t.Fatalf("start Redis: %v", err)
+	}
+	uri, err := ctr.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("Redis connection string: %v", err)
+	}
+	opts, err := redis.ParseURL(uri)
+	if err != nil {
+		t.Fatalf("parse %s: %v", uri, err)
+	}
+	rdb := redis.NewClient(opts)
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+// startRedisCluster starts a single-node Redis Cluster serving every slot and returns a
+// cluster client for it. The node announces its container address, so the client is
+// given the slot map with the mapped port instead of reading CLUSTER SLOTS. One node is
+// enough to exercise cluster semantics: commands and scripts on keys in different slots
+// still fail with CROSSSLOT.
+func startRedisCluster(t *testing.T) *redis.ClusterClient {
+	t.Helper()
+	ctx := context.Background()
+	ctr, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        redisImage(),
+			Cmd:          []string{"redis-server", "--cluster-enabled", "yes", "--appendonly", "no"},
+			ExposedPorts: []string{"6379/tcp"},
+			WaitingFor:   wait.ForLog("Ready to accept connections"),
+		},
+		Started: true,
+	})
+	testcontainers.CleanupContainer(t, ctr)
+	if err != nil {
+		t.Fatalf("start Redis Cluster: %v", err)
+	}
+	addr, err := ctr.PortEndpoint(ctx, "6379/tcp", "")
+	if err != nil {
+		t.Fatalf("Redis Cluster endpoint: %v", err)
+	}
+
+	node := redis.NewClient(&redis.Options{Addr: addr})
+	defer node.Close()
+	slots := make([]int, 16384)
+	for i := range slots {
+		slots[i] = i
+	}
+	if err := node.ClusterAddSlots(ctx, slots...).Err(); err != nil {
+		t.Fatalf("assign cluster slots: %v", err)
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		info, err := node.ClusterInfo(ctx).Result()
+		if err == nil && strings.Contains(info, "cluster_state:ok") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Redis Cluster did not become ready: %v %s", err, info)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	rdb := redis.NewClusterClient(&redis.ClusterOptions{
+		ClusterSlots: func(ctx context.Context) ([]redis.ClusterSlot, error) {
+			return []redis.ClusterSlot{{Start: 0, End: 16383, Nodes: []redis.ClusterNode{{Addr: addr}}}}, nil
+		},
+	})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+// seedQueue writes a BullMQ layout for the queue whose keys start with prefix: five job
+// hashes, three waiting and two active jobs, four delayed a minute out and one prioritized
+func seedQueue(t *testing.T, rdb redis.UniversalClient, prefix string) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		if err := rdb.HSet(ctx, prefix+":"+id, "name", "it-job", "data", "{}", "timestamp", now.UnixMilli()).Err(); err != nil {
+			t.Fatalf("seed job %s: %v", id, err)
+		}
+	}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("seed %s: %v", prefix, err)
+		}
+	}
+	must(rdb.LPush(ctx, prefix+":wait", "1", "2", "3").Err())
+	must(rdb.LPush(ctx, prefix+":active", "4", "5").Err())
+	due := float64(now.Add(time.Minute).UnixMilli())
+	for _, id := range []string{"6", "7", "8", "9"} {
+		must(rdb.ZAdd(ctx, prefix+":delayed", &redis.Z{Score: due, Member: id}).Err())
+	}
+	must(rdb.ZAdd(ctx, prefix+":prioritized", &redis.Z{Score: 1, Member: "10"}).Err())
+}
+
+// integrationServer returns a server on rdb without length caching, so every call reads
+// the queue
+func integrationServer(rdb redisCmdable) *server {
+	cfg := defaultServerConfig()
+	cfg.metricCacheTTL = 0
+	return newServer(rdb, cfg)
+}
+
+func integrationRef(metadata map[string]string) *pb.ScaledObjectRef {
+	return &pb.ScaledObjectRef{Name: "it-worker", Namespace: "it", ScalerMetadata: metadata}
+}
+
+// getMetrics calls GetMetrics and returns the values by metric name, the queue length
+// under ""
+func getMetrics(t *testing.T, s *server, metadata map[string]string) (map[string]int64, error) {
+	t.Helper()
+	resp, err := s.GetMetrics(context.Background(), &pb.GetMetricsRequest{ScaledObjectRef: integrationRef(metadata)})
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]int64, len(resp.MetricValues))
+	for i, v := range resp.MetricValues {
+		name := v.MetricName
+		if i == 0 {
+			name = ""
+		}
+		values[name] = v.MetricValue
+	}
+	return values, nil
+}
+
+// metricValue returns the value whose name contains base
+func metricValue(t *testing.T, values map[string]int64, base string) int64 {
+	t.Helper()
+	for name, v := range values {
+		if name != "" && strings.Contains(name, base) {
+			return v
+		}
+	}
+	t.Fatalf("no %s metric in %v", base, values)
+	return 0
+}
+
+func withMetadata(base map[string]string, extra ...string) map[string]string {
+	m := make(map[string]string, len(base)+len(extra)/2)
+	for k, v := range base {
+		m[k] = v
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		m[extra[i]] = extra[i+1]
+	}
+	return m
+}
+
+func TestIntegrationStandalone(t *testing.T) {
+	rdb := startRedis(t)
+	seedQueue(t, rdb, "bull:it-queue")
+	s := integrationServer(rdb)
+	ctx := context.Background()
+
+	lists := map[string]string{"waitList": "bull:it-queue:wait", "activeList": "bull:it-queue:active", "maxPods": "10"}
+
+	t.Run("backlog in wait and active", func(t *testing.T) {
+		active, err := s.IsActive(ctx, integrationRef(lists))
+		if err != nil {
+			t.Fatalf("IsActive: %v", err)
+		}
+		if !active.Result {
+			t.Error("IsActive = false, want true")
+		}
+		values, err := getMetrics(t, s, lists)
+		if err != nil {
+			t.Fatalf("GetMetrics: %v", err)
+		}
+		if values[""] != 5 {
+			t.Errorf("GetMetrics = %d, want wait + active = 5", values[""])
+		}
+	})
+
+	t.Run("delayed and prioritized sets", func(t *testing.T) {
+		sets := withMetadata(lists, "delayedSet", "bull:it-queue:delayed", "prioritizedSet", "bull:it-queue:prioritized",
+			"emitDelayedMetric", "true")
+		values, err := getMetrics(t, s, sets)
+		if err != nil {
+			t.Fatalf("GetMetrics: %v", err)
+		}
+		if values[""] != 10 {
+			t.Errorf("GetMetrics = %d, want wait + active + delayed + prioritized = 10", values[""])
+		}
+		if got := metricValue(t, values, delayedMetric); got != 4 {
+			t.Errorf("delayed metric = %d, want 4", got)
+		}
+	})
+
+	t.Run("derived delayed set is not counted by default", func(t *testing.T) {
+		values, err := getMetrics(t, s, map[string]string{"queueName": "it-queue", "maxPods": "10"})
+		if err != nil {
+			t.Fatalf("GetMetrics: %v", err)
+		}
+		if values[""] != 6 {
+			t.Errorf("GetMetrics = %d, want wait + active + prioritized = 6", values[""])
+		}
+	})
+
+	t.Run("queueName derives the BullMQ keys", func(t *testing.T) {
+		values, err := getMetrics(t, s, map[string]string{"queueName": "it-queue", "maxPods": "10", "includeDelayed": "all"})
+		if err != nil {
+			t.Fatalf("GetMetrics: %v", err)
+		}
+		if values[""] != 10 {
+			t.Errorf("GetMetrics = %d, want 10", values[""])
+		}
+	})
+
+	t.Run("list used as delayedSet", func(t *testing.T) {
+		_, err := getMetrics(t, s, withMetadata(lists, "delayedSet", "bull:it-queue:wait"))
+		if err == nil || !strings.Contains(err.Error(), "is not a sorted set") {
+			t.Errorf("GetMetrics error = %v, want a wrong type error", err)
+		}
+	})
+
+	t.Run("maxPods caps the metric", func(t *testing.T) {
+		values, err := getMetrics(t, s, withMetadata(lists, "maxPods", "2", "emitRawMetric", "true"))
+		if err != nil {
+			t.Fatalf("GetMetrics: %v", err)
+		}
+		if values[""] != 2 {
+			t.Errorf("GetMetrics = %d, want 2", values[""])
+		}
+		if got := metricValue(t, values, rawLengthMetric); got != 5 {
+			t.Errorf("raw metric = %d, want 5", got)
+		}
+	})
+
+	t.Run("paused queue", func(t *testing.T) {
+		paused := withMetadata(lists, "pausedKey", "bull:it-queue:meta")
+		// BullMQ pauses a queue by flagging its meta hash and renaming wait to paused
+		pipe := rdb.TxPipeline()
+		pipe.HSet(ctx, "bull:it-queue:meta", "paused", "1")
+		pipe.Rename(ctx, "bull:it-queue:wait", "bull:it-queue:paused")
+		if _, err := pipe.Exec(ctx); err != nil {
+			t.Fatalf("pause: %v", err)
+		}
+		active, err := s.IsActive(ctx, integrationRef(paused))
+		if err != nil {
+			t.Fatalf("IsActive: %v", err)
+		}
+		if active.Result {
+			t.Error("IsActive on a paused queue = true, want false")
+		}
+		values, err := getMetrics(t, s, paused)
+		if err != nil {
+			t.Fatalf("GetMetrics: %v", err)
+		}
+		if values[""] != 0 {
+			t.Errorf("GetMetrics on a paused queue = %d, want 0", values[""])
+		}
+		values, err = getMetrics(t, s, withMetadata(paused, "pausedMetric", "backlog"))
+		if err != nil {
+			t.Fatalf("GetMetrics: %v", err)
+		}
+		if values[""] != 5 {
+			t.Errorf("GetMetrics on a paused queue with pausedMetric backlog = %d, want 5", values[""])
+		}
+
+		pipe = rdb.TxPipeline()
+		pipe.HDel(ctx, "bull:it-queue:meta", "paused")
+		pipe.Rename(ctx, "bull:it-queue:paused", "bull:it-queue:wait")
+		if _, err := pipe.Exec(ctx); err != nil {
+			t.Fatalf("resume: %v", err)
+		}
+		values, err = getMetrics(t, s, paused)
+		if err != nil {
+			t.Fatalf("GetMetrics: %v", err)
+		}
+		if values[""] != 5 {
+			t.Errorf("GetMetrics after resuming = %d, want 5", values[""])
+		}
+	})
+
+	t.Run("drained queue", func(t *testing.T) {
+		if err := rdb.Del(ctx, "bull:it-queue:wait", "bull:it-queue:active").Err(); err != nil {
+			t.Fatalf("drain: %v", err)
+		}
+		active, err := s.IsActive(ctx, integrationRef(lists))
+		if err != nil {
+			t.Fatalf("IsActive: %v", err)
+		}
+		if active.Result {
+			t.Error("IsActive = true, want false")
+		}
+	})
+}
+
+func TestIntegrationCluster(t *testing.T) {
+	rdb := startRedisCluster(t)
+	seedQueue(t, rdb, "bull:{it-queue}")
+	seedQueue(t, rdb, "bull:it-untagged")
+	s := integrationServer(rdb)
+
+	tagged := map[string]string{"queueName": "it-queue", "queueHashTag": "true", "maxPods": "10", "includeDelayed": "all"}
+
+	t.Run("hash-tagged keys", func(t *testing.T) {
+		values, err := getMetrics(t, s, tagged)
+		if err != nil {
+			t.Fatalf("GetMetrics: %v", err)
+		}
+		if values[""] != 10 {
+			t.Errorf("GetMetrics = %d, want 10", values[""])
+		}
+	})
+
+	t.Run("hash-tagged keys read atomically", func(t *testing.T) {
+		values, err := getMetrics(t, s, withMetadata(tagged, "atomicRead", "true"))
+		if err != nil {
+			t.Fatalf("GetMetrics: %v", err)
+		}
+		if values[""] != 10 {
+			t.Errorf("GetMetrics = %d, want 10", values[""])
+		}
+	})
+
+	t.Run("untagged keys across slots", func(t *testing.T) {
+		untagged := map[string]string{"queueName": "it-untagged", "maxPods": "10", "includeDelayed": "all"}
+		values, err := getMetrics(t, s, untagged)
+		if err != nil {
+			t.Fatalf("GetMetrics: %v", err)
+		}
+		if values[""] != 10 {
+			t.Errorf("pipelined GetMetrics = %d, want 10", values[""])
+		}
+		_, err = getMetrics(t, s, withMetadata(untagged, "atomicRead", "true"))
+		if err == nil || !strings.Contains(err.Error(), "CROSSSLOT") {
+			t.Errorf("atomic GetMetrics error = %v, want CROSSSLOT", err)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"path"
+	"runtime/debug"
+	"time"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDHeader carries the request ID in gRPC metadata, in both directions
+const requestIDHeader = "x-request-id"
+
+// maxRequestIDLength bounds a caller-supplied request ID before it is logged
+const maxRequestIDLength = 64
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// withRequestID returns ctx carrying the caller's x-request-id, or a new random ID when
+// the caller sent none, so every log line of one call can be found together
+func withRequestID(ctx context.Context) (context.Context, string) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestIDHeader); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	if len(id) > maxRequestIDLength {
+		id = id[:maxRequestIDLength]
+	}
+	if id == "" {
+		buf := make([]byte, 8)
+		rand.Read(buf)
+		id = hex.EncodeToString(buf)
+	}
+	return context.WithValue(ctx, requestIDKey{}, id), id
+}
+
+// requestID returns the request ID carried by ctx, or "" outside a gRPC call
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// resultAttrsKey is the context key of the attributes a handler adds to its call's log line
+type resultAttrsKey struct{}
+
+// addResultAttrs adds attributes describing the outcome of a unary call, such as the queue
+// lengths read and the value returned, to the "RPC finished" line logUnary writes for it.
+// Outside a unary call it does nothing.
+func addResultAttrs(ctx context.Context, attrs ...any) {
+	if result, ok := ctx.Value(resultAttrsKey{}).(*[]any); ok {
+		*result = append(*result, attrs...)
+	}
+}
+
+// requestIDStream overrides the context of a server stream to carry the request ID
+type requestIDStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *requestIDStream) Context() context.Context {
+	return s.ctx
+}
+
+// interceptorOptions returns the server options that wrap every RPC with panic recovery,
+// a per-call log line and the version header. Recovery runs innermost so the log line
+// reports the Internal error a panic was turned into.
+func interceptorOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(versionUnary, logUnary, recoverUnary),
+		grpc.ChainStreamInterceptor(versionStream, logStream, recoverStream),
+	}
+}
+
+// recoverUnary turns a panic in a unary handler into an Internal error, so one bad
+// ScaledObject cannot crash the server and stop scaling for every other one
+func recoverUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(ctx, info.FullMethod, r)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// recoverStream is the streaming counterpart of recoverUnary
+func recoverStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(ss.Context(), info.FullMethod, r)
+		}
+	}()
+	return handler(srv, ss)
+}
+
+// panicError logs a recovered panic with its stack and returns the error sent to KEDA. The
+// handler's own observeRequest saw no error while unwinding, so the failure is counted here.
+func panicError(ctx context.Context, method string, r any) error {
+	requestErrorsTotal.WithLabelValues(path.Base(method)).Inc()
+	slog.Error("Recovered from panic in gRPC handler", "method", path.Base(method), "requestId", requestID(ctx),
+		"panic", r, "stack", string(debug.Stack()))
+	return status.Errorf(codes.Internal, "internal error in %s: %v", path.Base(method), r)
+}
+
+// logUnary assigns the call a request ID, echoed back in the x-request-id header, then logs
+// the method, ScaledObject, duration, status code and result of every unary call in one
+// line and records its latency
+func logUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, id := withRequestID(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, id))
+	var result []any
+	resp, err := handler(context.WithValue(ctx, resultAttrsKey{}, &result), req)
+
+	var ref *pb.ScaledObjectRef
+	switch r := req.(type) {
+	case *pb.ScaledObjectRef:
+		ref = r
+	case *pb.GetMetricsRequest:
+		ref = r.ScaledObjectRef
+	}
+	duration := time.Since(start)
+	requestDuration.WithLabelValues(path.Base(info.FullMethod), status.Code(err).String()).Observe(duration.Seconds())
+	attrs := []any{"method", path.Base(info.FullMethod), "requestId", id, "duration", duration, "code", status.Code(err)}
+	if ref != nil {
+		attrs = append(attrs, "namespace", ref.Namespace, "scaledObject", ref.Name)
+	}
+	logRPC(err, append(attrs, result...))
+	return resp, err
+}
+
+// logStream logs the method, duration and status code of every streaming call when it ends.
+// The ScaledObject arrives inside the stream, so the handler logs it itself.
+func logStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, id := withRequestID(ss.Context())
+	ss.SetHeader(metadata.Pairs(requestIDHeader, id))
+	err := handler(srv, &requestIDStream{ServerStream: ss, ctx: ctx})
+	logRPC(err, []any{"method", path.Base(info.FullMethod), "requestId", id, "duration", time.Since(start), "code", status.Code(err)})
+	return err
+}
+
+// logRPC logs a finished call, at warning level when it returned an error
+func logRPC(err error, attrs []any) {
+	if err != nil {
+		slog.Warn("RPC finished", append(attrs, "error", err)...)
+		return
+	}
+	slog.Info("RPC finished", attrs...)
+}
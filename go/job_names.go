@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// defaultJobNameScanLimit is how many job IDs of each list or set jobNameFilter
+	// inspects by default
+	defaultJobNameScanLimit = 1000
+	// jobNameCacheSize bounds the cached job names; the cache is emptied when it is full
+	jobNameCacheSize = 100000
+)
+
+// jobNameFilter counts only the jobs whose name matches one of patterns, for queues that
+// carry several job names consumed by different Deployments
+type jobNameFilter struct {
+	patterns  []string
+	scanLimit int64
+}
+
+// parseJobNameFilter reads jobNameFilter, comma-separated job names in which '*' matches
+// anything, and jobNameScanLimit. Names are read from job hashes found next to each key,
+// so the filter needs to know every key, and a consistent snapshot cannot include the
+// extra reads.
+func parseJobNameFilter(metadata map[string]string, keys queueKeys) (jobNameFilter, error) {
+	patterns := parseKeyList(metadata["jobNameFilter"])
+	limit, err := getMetadataPositiveInt(metadata, "jobNameScanLimit", defaultJobNameScanLimit)
+	if err != nil || len(patterns) == 0 {
+		return jobNameFilter{}, err
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return jobNameFilter{}, fmt.Errorf("invalid jobNameFilter pattern %q: %w", pattern, err)
+		}
+	}
+	if keys.waitPattern != "" {
+		return jobNameFilter{}, fmt.Errorf("jobNameFilter cannot be combined with waitListPattern")
+	}
+	if keys.atomic {
+		return jobNameFilter{}, fmt.Errorf("jobNameFilter cannot be combined with atomicRead")
+	}
+	return jobNameFilter{patterns: patterns, scanLimit: limit}, nil
+}
+
+// matches reports whether a job name passes the filter
+func (f jobNameFilter) matches(name string) bool {
+	for _, pattern := range f.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// id identifies the filter in queueKeys.id
+func (f jobNameFilter) id() string {
+	if len(f.patterns) == 0 {
+		return ""
+	}
+	return strings.Join(f.patterns, ",") + "/" + fmt.Sprint(f.scanLimit)
+}
+
+// jobNameCache remembers the name of each job hash read, since a job keeps its name for
+// life and a backlog is mostly the same jobs from one poll to the next
+type jobNameCache struct {
+	mu    sync.Mutex
+	names map[string]string
+}
+
+var cachedJobNames = &jobNameCache{names: make(map[string]string)}
+
+// get returns the cached name of a job hash
+func (c *jobNameCache) get(jobKey string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name, ok := c.names[jobKey]
+	return name, ok
+}
+
+// put caches the name of a job hash
+func (c *jobNameCache) put(jobKey, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.names) >= jobNameCacheSize {
+		clear(c.names)
+	}
+	c.names[jobKey] = name
+}
+
+// namedJobSource is a list or sorted set of job IDs whose count jobNameFilter narrows, and
+// its full length
+type namedJobSource struct {
+	key    string
+	sorted bool
+	total  int64
+}
+
+// countNamedJobs returns, for each source, the number of its jobs whose name passes the
+// filter. Up to scanLimit IDs are read from each, with one pipelined LRANGE or ZRANGE per
+// source, and the names not cached yet with one pipelined HGET per job. When a source
+// holds more jobs than that, the share of matches among the inspected ones is applied to
+// the rest, rounding up so a matching job is never counted as none.
+func (s *server) countNamedJobs(ctx context.Context, client redisCmdable, filter jobNameFilter, sources []namedJobSource) (map[string]int64, error) {
+	counts := make(map[string]int64, len(sources))
+	idCmds := make([]*redis.StringSliceCmd, len(sources))
+	err := s.redisOp(ctx, "job_ids_pipeline", func(ctx context.Context) error {
+		pipe := client.Pipeline()
+		for i, src := range sources {
+			if src.total == 0 {
+				continue
+			}
+			if src.sorted {
+				idCmds[i] = pipe.ZRange(ctx, src.key, 0, filter.scanLimit-1)
+			} else {
+				idCmds[i] = pipe.LRange(ctx, src.key, 0, filter.scanLimit-1)
+			}
+		}
+		if pipe.Len() == 0 {
+			return nil
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	// A key of the wrong type is reported by the count of the key itself
+	if err != nil && !isRedisReplyError(err) {
+		return nil, fmt.Errorf("failed to read job IDs for jobNameFilter: %w", err)
+	}
+
+	// BullMQ keeps each job in a hash named after its ID, next to the queue's other keys
+	jobKeys := make([][]string, len(sources))
+	var missing []string
+	for i, cmd := range idCmds {
+		if cmd == nil {
+			continue
+		}
+		prefix := sources[i].key[:strings.LastIndex(sources[i].key, ":")+1]
+		for _, id := range cmd.Val() {
+			// BullMQ markers in the wait list are not jobs
+			if strings.HasPrefix(id, "0:") {
+				continue
+			}
+			jobKey := prefix + id
+			if err := checkKeyAllowed(jobKey); err != nil {
+				return nil, err
+			}
+			jobKeys[i] = append(jobKeys[i], jobKey)
+			if _, ok := cachedJobNames.get(jobKey); !ok {
+				missing = append(missing, jobKey)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		nameCmds := make([]*redis.StringCmd, len(missing))
+		err := s.redisOp(ctx, "job_names_pipeline", func(ctx context.Context) error {
+			pipe := client.Pipeline()
+			for i, jobKey := range missing {
+				nameCmds[i] = pipe.HGet(ctx, jobKey, "name")
+			}
+			_, err := pipe.Exec(ctx)
+			return err
+		})
+		if err != nil && err != redis.Nil && !isRedisReplyError(err) {
+			return nil, fmt.Errorf("failed to read job names for jobNameFilter: %w", err)
+		}
+		for i, cmd := range nameCmds {
+			// A job removed since its ID was read has no hash, and is not cached so a
+			// reused ID is read again
+			if name, err := cmd.Result(); err == nil {
+				cachedJobNames.put(missing[i], name)
+			}
+		}
+	}
+
+	for i, src := range sources {
+		var matched int64
+		for _, jobKey := range jobKeys[i] {
+			if name, ok := cachedJobNames.get(jobKey); ok && filter.matches(name) {
+				matched++
+			}
+		}
+		if inspected := int64(len(jobKeys[i])); inspected > 0 && src.total > inspected {
+			matched = (matched*src.total + inspected - 1) / inspected
+		}
+		counts[src.key] = matched
+	}
+	slog.Debug("Counted jobs by name", "jobNameFilter", strings.Join(filter.patterns, ","), "counts", counts)
+	return counts, nil
+}
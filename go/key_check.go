@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// keyTypeCheck is a configured key and the Redis types it may legitimately have
+type keyTypeCheck struct {
+	field    string
+	key      string
+	expected []string
+}
+
+// keyTypeChecks lists every configured key with the types BullMQ stores it as
+func (k queueKeys) keyTypeChecks() []keyTypeCheck {
+	var checks []keyTypeCheck
+	add := func(field string, keys []string, expected ...string) {
+		for _, key := range keys {
+			checks = append(checks, keyTypeCheck{field: field, key: key, expected: expected})
+		}
+	}
+	add("waitList", k.wait, "list")
+	add("activeList", k.active, "list")
+	add("delayedSet", k.delayed, "zset")
+	add("prioritizedSet", k.prioritized, "zset")
+	add("stalledSet", k.stalled, "set", "list")
+	add("waitingChildrenSet", k.waitingChildren, "zset")
+	add("groupsKey", k.groups, "zset")
+	add("completedSet", k.completed, "zset")
+	add("failedSet", k.failed, "zset")
+	if k.paused != "" {
+		add("pausedKey", []string{k.paused}, "hash", "string")
+	}
+	return checks
+}
+
+// checkKeyTypesOnce runs checkKeyTypes the first time a key set is polled, and again on
+// later polls until it has read the types from Redis. A typo in a key name otherwise shows
+// up only as a queue that always reads zero.
+func (s *server) checkKeyTypesOnce(ctx context.Context, keys queueKeys, logger *slog.Logger) {
+	// Wait until the default client has connected, so the check is not spent on an outage
+	if keys.redis.host == "" && s.redisConn.err() != nil {
+		return
+	}
+	if !s.queueStates.markKeysChecked(keys.id()) {
+		return
+	}
+	if !s.checkKeyTypes(ctx, keys, logger) {
+		s.queueStates.unmarkKeysChecked(keys.id())
+	}
+}
+
+// checkKeyTypes reads the TYPE of every configured key in one pipelined round trip and
+// warns about keys of an unexpected type. Missing keys are normal for an empty queue and
+// are only logged at debug level. The check is diagnostic and never fails the request; it
+// reports whether the types were read.
+func (s *server) checkKeyTypes(ctx context.Context, keys queueKeys, logger *slog.Logger) bool {
+	checks := keys.keyTypeChecks()
+	client := s.clientFor(keys.redis)
+
+	var cmds []*redis.StatusCmd
+	err := s.redisOp(ctx, "type_pipeline", func(ctx context.Context) error {
+		pipe := client.Pipeline()
+		for _, check := range checks {
+			cmds = append(cmds, pipe.Type(ctx, check.key))
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	if err != nil && !isRedisReplyError(err) {
+		logger.Debug("Skipped key type check", "error", err)
+		return false
+	}
+
+	for i, cmd := range cmds {
+		check := checks[i]
+		actual, err := cmd.Result()
+		switch {
+		case err != nil:
+			logger.Debug("Failed to read key type", "field", check.field, "key", check.key, "error", err)
+		case actual == "none":
+			logger.Debug("Configured key does not exist yet", "field", check.field, "key", check.key)
+		case !slices.Contains(check.expected, actual):
+			logger.Warn("Configured key has an unexpected Redis type; check the metadata for a typo",
+				"field", check.field, "key", check.key, "type", actual, "expected", strings.Join(check.expected, " or "))
+		}
+	}
+	return true
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// scannedKeys is the result of one pattern scan together with the time it was taken
+type scannedKeys struct {
+	keys      []string
+	truncated bool
+	scannedAt time.Time
+}
+
+// scanCache keeps recent pattern scans keyed by Redis target and pattern, so polls within
+// ttl reuse the discovered key list instead of walking the keyspace again. Queues created
+// after a scan are picked up once its entry expires. A ttl of 0 disables it.
+type scanCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]scannedKeys
+}
+
+// newScanCache creates an empty cache whose entries expire after ttl
+func newScanCache(ttl time.Duration) *scanCache {
+	return &scanCache{ttl: ttl, entries: make(map[string]scannedKeys)}
+}
+
+// get returns the cached scan for an id if it is younger than the ttl
+func (c *scanCache) get(id string) (scannedKeys, bool) {
+	if c.ttl <= 0 {
+		return scannedKeys{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok || time.Since(entry.scannedAt) >= c.ttl {
+		return scannedKeys{}, false
+	}
+	return entry, true
+}
+
+// put stores a fresh scan, dropping expired entries so patterns no longer polled do not
+// accumulate. There are few patterns per deployment, so the sweep runs on every put.
+func (c *scanCache) put(id string, keys []string, truncated bool) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.Sub(entry.scannedAt) >= c.ttl {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[id] = scannedKeys{keys: keys, truncated: truncated, scannedAt: now}
+}
+
+// discoverKeys returns the keys matching keys.waitPattern, from the scan cache when a
+// recent scan of the same pattern on the same Redis exists
+func (s *server) discoverKeys(ctx context.Context, client redisCmdable, keys queueKeys) ([]string, bool, error) {
+	id := keys.waitPattern
+	if redisID := keys.redis.id(); redisID != "" {
+		id = redisID + "\x00" + id
+	}
+	if cached, ok := s.scanCache.get(id); ok {
+		return cached.keys, cached.truncated, nil
+	}
+
+	matched, truncated, err := s.scanKeys(ctx, client, keys.waitPattern)
+	if err != nil {
+		return nil, false, err
+	}
+	s.scanCache.put(id, matched, truncated)
+	slog.Debug("Discovered keys matching pattern", "waitListPattern", keys.waitPattern, "keys", len(matched))
+	return matched, truncated, nil
+}
+
+// scanKeys returns the keys matching a glob pattern, SCANning scanCount keys per call and
+// stopping at scanMaxKeys. truncated reports whether the cap cut the scan short. In cluster
+// mode every master is scanned, since SCAN only covers the node it runs on.
+func (s *server) scanKeys(ctx context.Context, client redisCmdable, pattern string) (keys []string, truncated bool, err error) {
+	var mu sync.Mutex
+	scanNode := func(ctx context.Context, node redisCmdable) error {
+		var cursor uint64
+		for {
+			var batch []string
+			err := s.redisOp(ctx, "scan", func(ctx context.Context) (err error) {
+				batch, cursor, err = node.Scan(ctx, cursor, pattern, s.scanCount).Result()
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			// Keys the pattern matches beyond ALLOWED_KEY_PATTERNS are left out
+			batch = slices.DeleteFunc(batch, func(key string) bool { return checkKeyAllowed(key) != nil })
+			mu.Lock()
+			keys = append(keys, batch...)
+			full := len(keys) >= s.scanMaxKeys
+			if full {
+				truncated = truncated || len(keys) > s.scanMaxKeys || cursor != 0
+				keys = keys[:s.scanMaxKeys]
+			}
+			mu.Unlock()
+
+			if full || cursor == 0 {
+				return nil
+			}
+		}
+	}
+
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+			return scanNode(ctx, master)
+		})
+	} else {
+		err = scanNode(ctx, client)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to scan waitListPattern '%s': %w", pattern, err)
+	}
+	return keys, truncated, nil
+}
+
+// readPatternWaitLength sums the lengths of the wait lists matching keys.waitPattern. Keys
+// already listed in waitList are skipped so they are not counted twice, and matches that
+// are not lists are skipped, since a broad glob can also match BullMQ's other keys. The
+// LLENs are pipelined scanCount keys at a time.
+func (s *server) readPatternWaitLength(ctx context.Context, client redisCmdable, keys queueKeys) (int64, error) {
+	matched, truncated, err := s.discoverKeys(ctx, client, keys)
+	if err != nil {
+		return 0, err
+	}
+	if truncated {
+		slog.Warn("waitListPattern matched more keys than SCAN_MAX_KEYS; the total only counts the first ones",
+			"waitListPattern", keys.waitPattern, "maxKeys", s.scanMaxKeys)
+	}
+
+	explicit := make(map[string]bool, len(keys.wait))
+	for _, key := range keys.wait {
+		explicit[key] = true
+	}
+	var lists []string
+	for _, key := range matched {
+		if !explicit[key] {
+			explicit[key] = true
+			lists = append(lists, key)
+		}
+	}
+
+	var total int64
+	var skipped int
+	for start := 0; start < len(lists); start += int(s.scanCount) {
+		batch := lists[start:min(start+int(s.scanCount), len(lists))]
+
+		var cmds []*redis.IntCmd
+		pipeErr := s.redisOp(ctx, "pattern_pipeline", func(ctx context.Context) error {
+			pipe := client.Pipeline()
+			for _, key := range batch {
+				cmds = append(cmds, pipe.LLen(ctx, key))
+			}
+			_, err := pipe.Exec(ctx)
+			return err
+		})
+		if pipeErr != nil && !isRedisReplyError(pipeErr) {
+			return 0, fmt.Errorf("failed to read waitListPattern '%s' keys: %w", keys.waitPattern, pipeErr)
+		}
+		for _, cmd := range cmds {
+			if cmd.Err() != nil {
+				skipped++
+				continue
+			}
+			total += cmd.Val()
+		}
+	}
+
+	slog.Debug("Summed wait lists matching pattern", "waitListPattern", keys.waitPattern,
+		"lists", len(lists)-skipped, "skipped", skipped, "waitLen", total)
+	return total, nil
+}
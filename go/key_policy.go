@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+)
+
+// errKeyNotAllowed is returned for a key outside ALLOWED_KEY_PATTERNS
+var errKeyNotAllowed = errors.New("key not allowed by ALLOWED_KEY_PATTERNS")
+
+// allowedKeyPatterns is ALLOWED_KEY_PATTERNS, the globs every key a trigger reads must
+// match, so a ScaledObject cannot point the scaler at keys it has no business reading.
+// Empty allows every key.
+var allowedKeyPatterns []string
+
+// namespaceKeyPrefix is NAMESPACE_KEY_PREFIX, a key prefix containing {namespace}, which
+// is replaced by the namespace of the ScaledObject. Every key a trigger reads must start
+// with it, so in a shared cluster a tenant can only scale on the queues of its own
+// namespace, whatever its trigger metadata says. Empty disables the check.
+var namespaceKeyPrefix string
+
+// parseNamespaceKeyPrefix validates NAMESPACE_KEY_PREFIX. Glob characters are refused, so
+// a waitListPattern starting with the prefix can only match keys that do too.
+func parseNamespaceKeyPrefix(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if !strings.Contains(value, "{namespace}") {
+		return "", fmt.Errorf("must contain {namespace}, got: %s", value)
+	}
+	if strings.ContainsAny(value, "*?[]\\") {
+		return "", fmt.Errorf("must not contain glob characters, got: %s", value)
+	}
+	return value, nil
+}
+
+// withNamespaceQueuePrefix makes the namespace's key prefix the queuePrefix of a trigger
+// that sets none, so keys derived from queueName land under it: with
+// NAMESPACE_KEY_PREFIX "bull:{namespace}:", queue "emails" in namespace "shop" reads
+// "bull:shop:emails:wait". It wins over a queuePrefix from CONFIG_FILE, which cannot
+// satisfy every namespace.
+func withNamespaceQueuePrefix(namespace string, metadata map[string]string) map[string]string {
+	if namespaceKeyPrefix == "" || namespace == "" || !strings.HasSuffix(namespaceKeyPrefix, ":") {
+		return metadata
+	}
+	if key, err := metadataKey(metadata, "queuePrefix"); err != nil || metadata[key] != "" {
+		return metadata
+	}
+	withPrefix := maps.Clone(metadata)
+	withPrefix["queuePrefix"] = strings.TrimSuffix(strings.ReplaceAll(namespaceKeyPrefix, "{namespace}", namespace), ":")
+	return withPrefix
+}
+
+// parseAllowedKeyPatterns reads ALLOWED_KEY_PATTERNS, comma-separated globs in which '*'
+// matches any run of characters except '/', '?' any one character and '[...]' a class
+func parseAllowedKeyPatterns(value string) ([]string, error) {
+	patterns := parseKeyList(value)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return patterns, nil
+}
+
+// checkKeyAllowed returns errKeyNotAllowed unless key matches one of allowedKeyPatterns.
+// A waitListPattern is checked as it is written, so "bull:*" allows "bull:*:wait" but not
+// "*:wait"; the keys its SCAN finds are checked again.
+func checkKeyAllowed(key string) error {
+	if len(allowedKeyPatterns) == 0 {
+		return nil
+	}
+	for _, pattern := range allowedKeyPatterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", errKeyNotAllowed, key)
+}
+
+// checkAllowedKeys verifies every key a trigger is configured to read, before any Redis
+// command is issued for it, and returns a PermissionDenied error naming the first key
+// outside ALLOWED_KEY_PATTERNS or the namespace's NAMESPACE_KEY_PREFIX. Job hashes are
+// read next to the lists naming them, so a list passes the prefix check only when the part
+// up to its last ':' does, which keeps job IDs from reaching outside the prefix.
+func checkAllowedKeys(namespace string, m scalerMetadata) error {
+	if len(allowedKeyPatterns) == 0 && namespaceKeyPrefix == "" {
+		return nil
+	}
+	var prefix string
+	if namespaceKeyPrefix != "" {
+		if namespace == "" {
+			return errorWithInfo(codes.PermissionDenied, "NAMESPACE_KEY_PREFIX requires the ScaledObject namespace", reasonKeyNotAllowed, nil)
+		}
+		prefix = strings.ReplaceAll(namespaceKeyPrefix, "{namespace}", namespace)
+	}
+	keys := m.keys
+	configured := slices.Concat(keys.wait, keys.active, keys.delayed, keys.prioritized, keys.stalled,
+		keys.waitingChildren, keys.groups, keys.completed, keys.failed, m.events.streams)
+	for _, key := range append([]string{keys.waitPattern, keys.paused, m.rateLimit.metaKey}, keys.pausedLists()...) {
+		if key != "" {
+			configured = append(configured, key)
+		}
+	}
+	for _, key := range configured {
+		scope := key
+		if key != keys.waitPattern {
+			scope = key[:strings.LastIndex(key, ":")+1]
+		}
+		if !strings.HasPrefix(scope, prefix) {
+			return errorWithInfo(codes.PermissionDenied,
+				fmt.Sprintf("key %q is outside the %q prefix of namespace %s", key, prefix, namespace),
+				reasonKeyNotAllowed, map[string]string{"key": key, "namespace": namespace})
+		}
+		if err := checkKeyAllowed(key); err != nil {
+			return errorWithInfo(codes.PermissionDenied, err.Error(), reasonKeyNotAllowed, map[string]string{"key": key})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into the pod for its API
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+const (
+	// secretFetchTimeout bounds a read of a Secret from the Kubernetes API
+	secretFetchTimeout = 5 * time.Second
+	// secretWatchTimeout is how long a single watch request runs before it is renewed
+	secretWatchTimeout = 5 * time.Minute
+	// secretIdleTTL is how long a Secret no trigger uses is still watched
+	secretIdleTTL = 10 * time.Minute
+)
+
+// secretAuthKeys maps the auth parameters to the Secret keys they are read from, first
+// match wins. The tls.crt, tls.key and ca.crt keys let a kubernetes.io/tls Secret be used
+// as it is.
+var secretAuthKeys = map[string][]string{
+	"username": {"username"},
+	"password": {"password"},
+	"tls":      {"tls"},
+	"ca":       {"ca", "ca.crt"},
+	"cert":     {"cert", "tls.crt"},
+	"key":      {"key", "tls.key"},
+}
+
+// allowedSecretNamespaces is ALLOWED_SECRET_NAMESPACES, the namespaces whose Secrets any
+// ScaledObject may name in credentialsSecretNamespace. Empty confines every ScaledObject to
+// the Secrets of its own namespace.
+var allowedSecretNamespaces []string
+
+// withSecretCredentials adds the Redis credentials of the Secret named by
+// credentialsSecretName, in credentialsSecretNamespace or the ScaledObject's namespace, to
+// the trigger metadata. Parameters already in the metadata, such as those from a
+// TriggerAuthentication, win over the Secret. The credentials are sent to the trigger's own
+// redisHost, so a Secret outside the ScaledObject's namespace is only read from
+// ALLOWED_SECRET_NAMESPACES; otherwise a tenant could have the scaler's service account
+// read another namespace's Secret and hand its password to a host the tenant controls.
+func withSecretCredentials(namespace string, metadata map[string]string) (map[string]string, error) {
+	name := metadata["credentialsSecretName"]
+	secretNamespace := metadata["credentialsSecretNamespace"]
+	if name == "" {
+		if secretNamespace != "" {
+			return nil, fmt.Errorf("credentialsSecretNamespace requires credentialsSecretName")
+		}
+		return metadata, nil
+	}
+	if metadata["redisHost"] == "" && metadata["redisAddress"] == "" && metadata["redisURL"] == "" {
+		return nil, fmt.Errorf("credentialsSecretName requires redisHost, redisAddress or redisURL")
+	}
+	if namespace == "" {
+		return nil, fmt.Errorf("credentialsSecretName requires the ScaledObject namespace")
+	}
+	if secretNamespace == "" {
+		secretNamespace = namespace
+	}
+	if secretNamespace != namespace && !slices.Contains(allowedSecretNamespaces, secretNamespace) {
+		return nil, fmt.Errorf("credentialsSecretNamespace %s is not the ScaledObject's namespace %s or in ALLOWED_SECRET_NAMESPACES, "+
+			"and its credentials would be sent to the trigger's redisHost", secretNamespace, namespace)
+	}
+
+	data, err := kubeSecrets.get(secretNamespace, name)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]string, len(metadata)+len(secretAuthKeys))
+	for param, keys := range secretAuthKeys {
+		for _, key := range keys {
+			if value, ok := data[key]; ok {
+				merged[param] = string(value)
+				break
+			}
+		}
+	}
+	registerSecret(merged["password"])
+	registerSecret(merged["key"])
+	for key, value := range metadata {
+		if value != "" || merged[key] == "" {
+			merged[key] = value
+		}
+	}
+	return merged, nil
+}
+
+// kubeSecret is the part of a Kubernetes Secret the scaler reads. Data values are base64
+// in JSON, which encoding/json decodes into the byte slices.
+type kubeSecret struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string][]byte `json:"data"`
+}
+
+// kubeClient is a minimal client for the Kubernetes API, authenticated as the pod's
+// service account. Reading Secrets needs a Role granting get, list and watch on them.
+type kubeClient struct {
+	baseURL string
+	http    *http.Client
+}
+
+var kubeAPI struct {
+	once   sync.Once
+	client *kubeClient
+	err    error
+}
+
+// inClusterKubeClient returns the client for the API server of the cluster the scaler runs in
+func inClusterKubeClient() (*kubeClient, error) {
+	kubeAPI.once.Do(func() {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			kubeAPI.err = errors.New("not running in Kubernetes: KUBERNETES_SERVICE_HOST is not set")
+			return
+		}
+		caPEM, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+		if err != nil {
+			kubeAPI.err = fmt.Errorf("failed to read the Kubernetes API CA: %w", err)
+			return
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caPEM) {
+			kubeAPI.err = errors.New("no certificates found in the Kubernetes API CA")
+			return
+		}
+		kubeAPI.client = &kubeClient{
+			baseURL: "https://" + net.JoinHostPort(host, port),
+			http: &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+			}},
+		}
+	})
+	return kubeAPI.client, kubeAPI.err
+}
+
+// get issues a GET to the API server. The token is read for every request, since
+// Kubernetes rotates projected service account tokens.
+func (c *kubeClient) get(ctx context.Context, path string) (*http.Response, error) {
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the service account token: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// getSecret reads one Secret
+func (c *kubeClient) getSecret(ctx context.Context, namespace, name string) (kubeSecret, error) {
+	var secret kubeSecret
+	resp, err := c.get(ctx, "/api/v1/namespaces/"+url.PathEscape(namespace)+"/secrets/"+url.PathEscape(name))
+	if err != nil {
+		return secret, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&secret)
+	return secret, err
+}
+
+// cachedSecret is a Secret's data as last read or watched
+type cachedSecret struct {
+	data     map[string][]byte
+	lastUsed time.Time
+}
+
+// secretCache holds the Secrets named by credentialsSecretName. Each is read on first use
+// and then kept current by a watch, so polls never wait on the Kubernetes API.
+type secretCache struct {
+	mu      sync.Mutex
+	secrets map[string]*cachedSecret
+}
+
+var kubeSecrets = &secretCache{secrets: make(map[string]*cachedSecret)}
+
+// get returns the data of a Secret, reading it and starting its watch on first use
+func (c *secretCache) get(namespace, name string) (map[string][]byte, error) {
+	id := namespace + "/" + name
+	c.mu.Lock()
+	if cached, ok := c.secrets[id]; ok {
+		cached.lastUsed = time.Now()
+		c.mu.Unlock()
+		return cached.data, nil
+	}
+	c.mu.Unlock()
+
+	client, err := inClusterKubeClient()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read Secret %s: %w", id, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretFetchTimeout)
+	defer cancel()
+	secret, err := client.getSecret(ctx, namespace, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Secret %s: %w", id, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A concurrent first use may have cached it already, with its own watch
+	if _, ok := c.secrets[id]; !ok {
+		c.secrets[id] = &cachedSecret{data: secret.Data, lastUsed: time.Now()}
+		go c.watch(client, namespace, name, secret.Metadata.ResourceVersion)
+		slog.Info("Watching Secret for Redis credentials", "secret", id)
+	}
+	return secret.Data, nil
+}
+
+// watch keeps a cached Secret current until it is deleted or no trigger has used it for
+// secretIdleTTL. A failed watch is retried with backoff from a fresh read, since its
+// resourceVersion may have expired.
+func (c *secretCache) watch(client *kubeClient, namespace, name, resourceVersion string) {
+	id := namespace + "/" + name
+	backoff := time.Second
+	for {
+		c.mu.Lock()
+		cached, ok := c.secrets[id]
+		if ok && time.Since(cached.lastUsed) > secretIdleTTL {
+			delete(c.secrets, id)
+			ok = false
+		}
+		c.mu.Unlock()
+		if !ok {
+			slog.Info("Stopped watching Secret", "secret", id)
+			return
+		}
+
+		var err error
+		if resourceVersion, err = c.watchOnce(client, namespace, name, resourceVersion); err == nil {
+			backoff = time.Second
+			continue
+		}
+		slog.Warn("Watching Secret failed, reading it again", "secret", id, "retryIn", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, time.Minute)
+
+		ctx, cancel := context.WithTimeout(context.Background(), secretFetchTimeout)
+		secret, err := client.getSecret(ctx, namespace, name)
+		cancel()
+		if err != nil {
+			slog.Warn("Failed to read Secret, keeping the cached credentials", "secret", id, "error", err)
+			continue
+		}
+		c.update(id, secret.Data)
+		resourceVersion = secret.Metadata.ResourceVersion
+	}
+}
+
+// watchOnce runs one watch request of up to secretWatchTimeout and returns the
+// resourceVersion to resume from
+func (c *secretCache) watchOnce(client *kubeClient, namespace, name, resourceVersion string) (string, error) {
+	id := namespace + "/" + name
+	ctx, cancel := context.WithTimeout(context.Background(), secretWatchTimeout+secretFetchTimeout)
+	defer cancel()
+
+	query := url.Values{
+		"watch":           {"true"},
+		"fieldSelector":   {"metadata.name=" + name},
+		"resourceVersion": {resourceVersion},
+		"timeoutSeconds":  {strconv.Itoa(int(secretWatchTimeout / time.Second))},
+	}
+	resp, err := client.get(ctx, "/api/v1/namespaces/"+url.PathEscape(namespace)+"/secrets?"+query.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := decoder.Decode(&event); err == io.EOF {
+			return resourceVersion, nil
+		} else if err != nil {
+			return "", err
+		}
+
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			var secret kubeSecret
+			if err := json.Unmarshal(event.Object, &secret); err != nil {
+				return "", err
+			}
+			c.update(id, secret.Data)
+			resourceVersion = secret.Metadata.ResourceVersion
+			slog.Info("Secret changed, new Redis connections use its credentials", "secret", id)
+		case "DELETED":
+			c.mu.Lock()
+			delete(c.secrets, id)
+			c.mu.Unlock()
+			slog.Warn("Secret with Redis credentials was deleted", "secret", id)
+			return resourceVersion, nil
+		case "ERROR":
+			return "", fmt.Errorf("watch error: %s", event.Object)
+		}
+	}
+}
+
+// update replaces the data of a cached Secret, unless it has been dropped meanwhile
+func (c *secretCache) update(id string, data map[string][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.secrets[id]; ok {
+		cached.data = data
+	}
+}
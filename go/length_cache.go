@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// cachedLengths is a queue length read together with the time it was taken
+type cachedLengths struct {
+	lengths queueLengths
+	readAt  time.Time
+}
+
+// lengthCache keeps recent queue length reads keyed by queueKeys.id(), so IsActive and
+// GetMetrics calls for the same keys within ttl reuse one Redis read. A ttl of 0 disables it.
+type lengthCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]cachedLengths
+	lastSweep time.Time
+}
+
+// newLengthCache creates an empty cache whose entries expire after ttl
+func newLengthCache(ttl time.Duration) *lengthCache {
+	return &lengthCache{
+		ttl:       ttl,
+		entries:   make(map[string]cachedLengths),
+		lastSweep: time.Now(),
+	}
+}
+
+// get returns the cached lengths for a key set if they are younger than the ttl, counting
+// the lookup as a hit or miss while the cache is enabled
+func (c *lengthCache) get(id string) (queueLengths, bool) {
+	if c.ttl <= 0 {
+		return queueLengths{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok || time.Since(entry.readAt) >= c.ttl {
+		lengthCacheLookups.WithLabelValues("miss").Inc()
+		return queueLengths{}, false
+	}
+	lengthCacheLookups.WithLabelValues("hit").Inc()
+	return entry.lengths, true
+}
+
+// put stores a fresh read. Expired entries are swept at most once per ttl so the map
+// does not grow with ScaledObjects that are no longer polled.
+func (c *lengthCache) put(id string, lengths queueLengths) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) >= c.ttl {
+		c.lastSweep = now
+		for key, entry := range c.entries {
+			if now.Sub(entry.readAt) >= c.ttl {
+				delete(c.entries, key)
+			}
+		}
+	}
+	c.entries[id] = cachedLengths{lengths: lengths, readAt: now}
+}
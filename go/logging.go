@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+)
+
+// setupLogging installs the process-wide structured logger. LOG_FORMAT selects "text"
+// (the default, for local development) or "json" for log shippers, and LOG_LEVEL sets
+// the minimum level: debug, info, warn or error. Secrets are redacted from every line.
+func setupLogging() {
+	var level slog.Level
+	switch levelStr := strings.ToLower(getEnvDefault("LOG_LEVEL", "info")); levelStr {
+	case "debug":
+		level = slog.LevelDebug
+	case "info":
+		level = slog.LevelInfo
+	case "warn", "warning":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		fatal("Invalid LOG_LEVEL: must be one of debug, info, warn, error", "value", levelStr)
+	}
+
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	var handler slog.Handler
+	switch format := strings.ToLower(getEnvDefault("LOG_FORMAT", "text")); format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		fatal("Invalid LOG_FORMAT: must be text or json", "value", format)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// fatal logs msg at error level with the given attributes and exits the process
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestLogger returns a logger that tags every line with the gRPC method, the
+// ScaledObject being served and the request ID, so logs can be filtered per namespace and
+// object, and the lines of one call found together
+func requestLogger(ctx context.Context, method string, ref *pb.ScaledObjectRef) *slog.Logger {
+	logger := slog.With("method", method, "namespace", ref.Namespace, "scaledObject", ref.Name)
+	if id := requestID(ctx); id != "" {
+		logger = logger.With("requestId", id)
+	}
+	return logger
+}
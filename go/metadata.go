@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+)
+
+// scalerMetadata is the parsed and validated trigger metadata of a ScaledObject
+type scalerMetadata struct {
+	keys                queueKeys
+	maxPods             int64 // 0 when unset
+	capMetric           bool  // cap length-based metric values at maxPods
+	targetSize          float64
+	activationThreshold int64
+	minActive           int64
+	metricPercentile    float64 // 0 for instantaneous values
+	sampleBufferSize    int
+	smoothingFactor     float64       // 0 disables EMA smoothing
+	smoothingWindow     time.Duration // report the max over this window when positive
+	reportWaitLatency   bool
+	emitDelayed         bool
+	emitRaw             bool
+	stalledTarget       int64         // scale on stalled jobs as a separate metric when positive
+	failedThreshold     int64         // scale on failures per minute as a separate metric when positive
+	ageTarget           int64         // scale on the oldest job's age in seconds as a separate metric when positive
+	completionWindow    time.Duration // sliding window for the completion and failure rates, 0 for the last poll
+	respectRateLimit    bool          // cap the metric at the jobs the rate limit lets through
+	rateLimit           rateLimitConfig
+	drain               drainConfig // report the pods needed to clear the backlog in time when set
+	scaleOnAge          bool
+	scaleOnEvents       bool // scale on the BullMQ events stream backlog instead of the jobs
+	events              eventsConfig
+	weights             queueWeights
+	metricName          string        // overrides the scoped bull_queue_length name when set
+	metricSuffix        string        // appended to scoped metric names with metricNameScope "queue"
+	pausedBacklog       bool          // report the backlog rather than 0 while the queue is paused
+	aggregateMax        bool          // report the busiest queue rather than the sum across queues
+	redisFallback       string        // what to report while the Redis circuit breaker is open, "" for an error
+	fallbackReplicas    int64         // replicas to ask for with redisFailureFallback "replicas"
+	staleValueTTL       time.Duration // answer with the last good values for this long when Redis fails
+}
+
+// metricNamePrefix is METRIC_NAME_PREFIX, prepended to every metric name the scaler
+// reports to KEDA. NewServer sets it before serving.
+var metricNamePrefix string
+
+// lengthMetricName returns the name of the main scaling metric. GetMetricSpec and
+// GetMetrics both use it, since KEDA matches metric values to specs by name.
+func (m scalerMetadata) lengthMetricName(ref *pb.ScaledObjectRef) string {
+	if m.metricName != "" {
+		return metricNamePrefix + m.metricName
+	}
+	return m.scopedName(queueLengthMetric, ref)
+}
+
+// scopedName returns a metric name scoped to the ScaledObject and, with metricNameScope
+// "queue", to the queue, so several triggers of one ScaledObject get distinct names
+func (m scalerMetadata) scopedName(base string, ref *pb.ScaledObjectRef) string {
+	name := metricNamePrefix + scopedMetricName(base, ref)
+	if m.metricSuffix != "" {
+		name += "_" + m.metricSuffix
+	}
+	return name
+}
+
+// parseRateLimit reads the respectRateLimit settings. Without rateLimitMax the limit is read
+// from the meta hash of the queue, which is only known for a single queue.
+func (m *scalerMetadata) parseRateLimit(metadata map[string]string, keysResolved bool) error {
+	if m.scaleOnAge {
+		return fmt.Errorf("respectRateLimit cannot be combined with scaleOn oldestJobAge")
+	}
+	var err error
+	if m.rateLimit.limit.max, err = getMetadataNonNegativeInt(metadata, "rateLimitMax", 0); err != nil {
+		return err
+	}
+	if m.rateLimit.limit.duration, err = getMetadataDuration(metadata, "rateLimitDuration", 0); err != nil {
+		return err
+	}
+	if m.rateLimit.window, err = getMetadataDuration(metadata, "rateLimitWindow", defaultRateLimitWindow); err != nil {
+		return err
+	}
+	if m.rateLimit.window == 0 {
+		return fmt.Errorf("rateLimitWindow must be positive")
+	}
+	if (m.rateLimit.limit.max > 0) != (m.rateLimit.limit.duration > 0) {
+		return fmt.Errorf("rateLimitMax and rateLimitDuration must be set together")
+	}
+	if m.rateLimit.limit.max > 0 || !keysResolved {
+		return nil
+	}
+	if len(m.keys.wait) != 1 {
+		return fmt.Errorf("respectRateLimit requires rateLimitMax unless exactly one waitList or queueName is set")
+	}
+	m.rateLimit.metaKey = jobKeyPrefix(m.keys.wait[0]) + "meta"
+	return nil
+}
+
+// validateScalerMetadata parses every trigger metadata key and reports all missing or
+// invalid fields in a single InvalidArgument error, so a misconfigured ScaledObject can be
+// fixed in one pass rather than one KEDA poll cycle per mistake
+func validateScalerMetadata(namespace string, metadata map[string]string) (scalerMetadata, error) {
+	if err := checkMetadataPresent(metadata); err != nil {
+		return scalerMetadata{}, err
+	}
+	metadata = withNamespaceQueuePrefix(namespace, metadata)
+	metadata = withMetadataDefaults(metadata)
+	registerSensitiveMetadata(metadata)
+
+	var m scalerMetadata
+	var problems []string
+	var err error
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	keys, keysErr := resolveQueueKeys(namespace, metadata)
+	check(keysErr)
+	m.keys = keys
+
+	// maxPods is optional since KEDA's maxReplicaCount already bounds the replicas. When it
+	// is set the metric is capped, unless capMetricValue turns that off.
+	if maxPodsStr := metadata["maxPods"]; maxPodsStr != "" {
+		if m.maxPods, err = strconv.ParseInt(maxPodsStr, 10, 64); err != nil || m.maxPods < 0 {
+			check(fmt.Errorf("maxPods must be a non-negative integer (0 disables the cap), got: %s", maxPodsStr))
+		}
+	}
+	m.capMetric, err = getMetadataBool(metadata, "capMetricValue", m.maxPods > 0)
+	check(err)
+	if m.capMetric && m.maxPods == 0 {
+		check(fmt.Errorf("capMetricValue requires a positive maxPods"))
+	}
+
+	targetKey, err := metadataKey(metadata, "targetSize")
+	check(err)
+	m.targetSize, err = getMetadataPositiveFloat(metadata, targetKey, 1)
+	check(err)
+	activationKey, err := metadataKey(metadata, "activationThreshold")
+	check(err)
+	m.activationThreshold, err = getMetadataNonNegativeInt(metadata, activationKey, 0)
+	check(err)
+	m.minActive, err = getMetadataNonNegativeInt(metadata, "minActive", 0)
+	check(err)
+	m.metricPercentile, err = parseMetricType(metadata["metricType"])
+	check(err)
+
+	m.sampleBufferSize = defaultSampleBufferSize
+	if sizeStr := metadata["sampleBufferSize"]; sizeStr != "" {
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil || size <= 0 || size > maxSampleBufferSize {
+			check(fmt.Errorf("sampleBufferSize must be an integer between 1 and %d, got: %s", maxSampleBufferSize, sizeStr))
+		}
+		m.sampleBufferSize = size
+	}
+
+	m.smoothingFactor, err = getMetadataNonNegativeFloat(metadata, "smoothingFactor", 0)
+	if err == nil && m.smoothingFactor >= 1 {
+		err = fmt.Errorf("smoothingFactor must be at least 0 and less than 1, got: %s", metadata["smoothingFactor"])
+	}
+	check(err)
+	m.smoothingWindow, err = getMetadataDuration(metadata, "smoothingWindow", 0)
+	check(err)
+	switch mode := metadata["smoothingMode"]; mode {
+	case "", "ema":
+		if m.smoothingWindow > 0 {
+			check(fmt.Errorf("smoothingWindow requires smoothingMode max; ema smoothing uses smoothingFactor"))
+		}
+	case "max":
+		if m.smoothingWindow == 0 {
+			check(fmt.Errorf("smoothingMode max requires a positive smoothingWindow"))
+		}
+		if m.smoothingFactor > 0 {
+			check(fmt.Errorf("smoothingMode max cannot be combined with smoothingFactor"))
+		}
+	default:
+		check(fmt.Errorf("smoothingMode must be \"ema\" or \"max\", got: %s", mode))
+	}
+
+	m.reportWaitLatency, err = getMetadataBool(metadata, "reportWaitLatency", false)
+	check(err)
+	m.emitDelayed, err = getMetadataBool(metadata, "emitDelayedMetric", false)
+	check(err)
+	// When the keys did not resolve, their error already explains what is missing
+	if m.emitDelayed && keysErr == nil && len(m.keys.delayed) == 0 {
+		check(fmt.Errorf("emitDelayedMetric requires the delayedSet or queueName metadata key"))
+	}
+	m.emitRaw, err = getMetadataBool(metadata, "emitRawMetric", false)
+	check(err)
+	m.stalledTarget, err = getMetadataNonNegativeInt(metadata, "stalledTarget", 0)
+	check(err)
+	if m.stalledTarget > 0 && keysErr == nil && len(m.keys.stalled) == 0 {
+		check(fmt.Errorf("stalledTarget requires the stalledSet or includeStalled metadata key"))
+	}
+	m.failedThreshold, err = getMetadataNonNegativeInt(metadata, "failedThreshold", 0)
+	check(err)
+	if m.failedThreshold > 0 && keysErr == nil && len(m.keys.failed) == 0 {
+		check(fmt.Errorf("failedThreshold requires the failedSet metadata key"))
+	}
+	m.completionWindow, err = getMetadataDuration(metadata, "completionWindow", 0)
+	check(err)
+	scaleOn, err := parseScaleOn(metadata["scaleOn"])
+	check(err)
+	m.scaleOnAge = scaleOn == scaleOnOldestJobAge
+	m.scaleOnEvents = scaleOn == scaleOnEventsBacklog
+	check(m.parseEventsStream(metadata, keysErr == nil))
+	m.ageTarget, err = getMetadataNonNegativeInt(metadata, "ageTarget", 0)
+	check(err)
+	if m.ageTarget > 0 && m.scaleOnAge {
+		check(fmt.Errorf("ageTarget cannot be combined with scaleOn oldestJobAge, which already scales on the age"))
+	}
+
+	m.respectRateLimit, err = getMetadataBool(metadata, "respectRateLimit", false)
+	check(err)
+	if m.respectRateLimit {
+		check(m.parseRateLimit(metadata, keysErr == nil))
+	}
+	check(m.parseDrainTime(metadata, metadata[targetKey] != "", keysErr == nil))
+	if keysErr == nil && m.keys.lookahead > 0 && len(m.keys.delayed) == 0 {
+		check(fmt.Errorf("scheduleLookahead requires delayed sets, from queueName or delayedSet"))
+	}
+
+	m.metricName, err = parseMetricName(metadata["metricName"])
+	check(err)
+	switch scope := metadata["metricNameScope"]; scope {
+	case "", "scaledObject":
+	case "queue":
+		// Queue names read better than the derived keys they expand to
+		queue := keys.name()
+		if nameKey, _ := metadataKey(metadata, "queueName"); metadata[nameKey] != "" {
+			queue = strings.Join(parseKeyList(metadata[nameKey]), "_")
+		}
+		m.metricSuffix = sanitizeMetricPart(queue)
+	default:
+		check(fmt.Errorf("metricNameScope must be \"scaledObject\" or \"queue\", got: %s", scope))
+	}
+	switch aggregation := metadata["aggregation"]; aggregation {
+	case "", "sum":
+	case "max":
+		m.aggregateMax = true
+		// Per-queue counts pair the i-th key of each kind, so the key lists must line up
+		if keysErr == nil {
+			check(checkQueuesAligned(m.keys))
+		}
+	default:
+		check(fmt.Errorf("aggregation must be \"sum\" or \"max\", got: %s", aggregation))
+	}
+	switch pausedMetric := metadata["pausedMetric"]; pausedMetric {
+	case "", "zero":
+	case "backlog":
+		m.pausedBacklog = true
+	default:
+		check(fmt.Errorf("pausedMetric must be \"zero\" or \"backlog\", got: %s", pausedMetric))
+	}
+
+	switch fallback := metadata["redisFailureFallback"]; fallback {
+	case "", fallbackError:
+	case fallbackZero, fallbackReplicas:
+		m.redisFallback = fallback
+	default:
+		check(fmt.Errorf("redisFailureFallback must be \"error\", \"zero\" or \"replicas\", got: %s", fallback))
+	}
+	m.fallbackReplicas, err = getMetadataNonNegativeInt(metadata, "fallbackReplicas", 0)
+	check(err)
+	if m.redisFallback == fallbackReplicas && m.fallbackReplicas == 0 {
+		check(fmt.Errorf("redisFailureFallback replicas requires a positive fallbackReplicas"))
+	}
+	if m.redisFallback != fallbackReplicas && m.fallbackReplicas > 0 {
+		check(fmt.Errorf("fallbackReplicas requires redisFailureFallback replicas"))
+	}
+
+	m.staleValueTTL, err = getMetadataDuration(metadata, "staleValueTTL", 0)
+	check(err)
+
+	m.weights.wait, err = getMetadataNonNegativeFloat(metadata, "waitWeight", 1)
+	check(err)
+	m.weights.active, err = getMetadataNonNegativeFloat(metadata, "activeWeight", 1)
+	check(err)
+	m.weights.delayed, err = getMetadataNonNegativeFloat(metadata, "delayedWeight", 1)
+	check(err)
+	// Leaving active jobs out suits ScaledJobs with the accurate strategy, which already
+	// subtract the running jobs and would otherwise count them twice
+	countActive, err := getMetadataBool(metadata, "countActive", true)
+	check(err)
+	if !countActive {
+		if metadata["activeWeight"] != "" {
+			check(fmt.Errorf("countActive false cannot be combined with activeWeight"))
+		}
+		m.weights.active = 0
+	}
+	if m.weights == (queueWeights{}) {
+		check(fmt.Errorf("waitWeight, activeWeight and delayedWeight cannot all be 0, the metric would never scale out"))
+	} else if m.weights.wait == 0 && m.weights.active == 0 && keysErr == nil && !m.keys.countDelayed {
+		check(fmt.Errorf("waitWeight and activeWeight are 0 and delayed jobs are not counted (see includeDelayed), the metric would never scale out"))
+	}
+
+	if len(problems) > 0 {
+		return scalerMetadata{}, invalidMetadataError("invalid trigger metadata: "+strings.Join(problems, "; "), problems)
+	}
+	if err := checkAllowedKeys(namespace, m); err != nil {
+		return scalerMetadata{}, err
+	}
+	return m, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// waitJobsGauge, activeJobsGauge and delayedJobsGauge report the backlog of each queue a
+// ScaledObject reads, so dashboards and alerts can reuse the scaler's reads instead of
+// running a separate exporter. They are informational and do not feed into scaling.
+var waitJobsGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bull_queue_wait_jobs",
+		Help: "Jobs waiting in each queue at the last IsActive/GetMetrics call, including prioritized jobs.",
+	},
+	[]string{"namespace", "scaled_object", "queue"},
+)
+
+var activeJobsGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bull_queue_active_jobs",
+		Help: "Jobs being processed in each queue at the last IsActive/GetMetrics call.",
+	},
+	[]string{"namespace", "scaled_object", "queue"},
+)
+
+var delayedJobsGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bull_queue_delayed_jobs",
+		Help: "Jobs in the delayed set of each queue at the last IsActive/GetMetrics call.",
+	},
+	[]string{"namespace", "scaled_object", "queue"},
+)
+
+// waitLatencyGauge reports the approximate time jobs spend in wait before becoming active
+var waitLatencyGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bull_queue_wait_latency_seconds",
+		Help: "Approximate time jobs spend waiting before becoming active, when reportWaitLatency is enabled.",
+	},
+	[]string{"namespace", "scaled_object"},
+)
+
+// completionRateGauge reports how fast jobs finish, when completedSet or failedSet is set
+var completionRateGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bull_queue_completion_rate",
+		Help: "Jobs completed or failed per minute between the last two GetMetrics calls, when completedSet or failedSet is configured.",
+	},
+	[]string{"namespace", "scaled_object"},
+)
+
+// queueJobsGauge reports the last observed job counts per ScaledObject, by queue state
+var queueJobsGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bull_queue_jobs",
+		Help: "Jobs observed at the last IsActive/GetMetrics call, by state (wait, active, delayed, prioritized, stalled, waiting_children, grouped, total).",
+	},
+	[]string{"namespace", "scaled_object", "state"},
+)
+
+// requestsTotal counts gRPC calls from KEDA by method
+var requestsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "scaler_requests_total",
+		Help: "Total number of external scaler gRPC calls.",
+	},
+	[]string{"method"},
+)
+
+// requestErrorsTotal counts gRPC calls that returned an error, by method
+var requestErrorsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "scaler_request_errors_total",
+		Help: "Total number of external scaler gRPC calls that returned an error.",
+	},
+	[]string{"method"},
+)
+
+// requestDuration tracks the latency of unary gRPC calls by method and status code.
+// Streams are left out, since their duration is the lifetime of the stream.
+var requestDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "scaler_request_duration_seconds",
+		Help:    "Latency of unary external scaler gRPC calls, by method and gRPC status code.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+	},
+	[]string{"method", "code"},
+)
+
+// redisErrorsTotal counts failed Redis commands by command and kind of failure
+var redisErrorsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "scaler_redis_errors_total",
+		Help: "Total number of Redis commands that failed, by kind (timeout, reply, connection).",
+	},
+	[]string{"command", "kind"},
+)
+
+// lengthCacheLookups counts queue length reads served from the cache or from Redis
+var lengthCacheLookups = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "scaler_length_cache_lookups_total",
+		Help: "Queue length reads by result: hit (served from METRIC_CACHE_TTL cache) or miss (read from Redis).",
+	},
+	[]string{"result"},
+)
+
+// sharedReads counts queue length reads that shared a Redis round trip with concurrent
+// identical reads
+var sharedReads = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "scaler_shared_reads_total",
+		Help: "Queue length reads that shared one Redis round trip with concurrent identical reads (SINGLEFLIGHT_ENABLED).",
+	},
+)
+
+// redisCircuitOpenGauge reports which Redis endpoints have an open circuit breaker
+var redisCircuitOpenGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "scaler_redis_circuit_open",
+		Help: "1 while the circuit breaker of a Redis endpoint is open and queue reads fail fast, 0 otherwise.",
+	},
+	[]string{"redis"},
+)
+
+// redisFailoversTotal counts changes of the active REDIS_ADDRESSES endpoint
+var redisFailoversTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "scaler_redis_failovers_total",
+		Help: "Total number of times the scaler switched to another REDIS_ADDRESSES endpoint, by the endpoint switched to.",
+	},
+	[]string{"address"},
+)
+
+// redisCallDuration tracks the latency of individual Redis commands
+var redisCallDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "scaler_redis_call_duration_seconds",
+		Help:    "Latency of Redis commands issued by the scaler.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	},
+	[]string{"command"},
+)
+
+func init() {
+	prometheus.MustRegister(
+		waitJobsGauge,
+		activeJobsGauge,
+		delayedJobsGauge,
+		waitLatencyGauge,
+		completionRateGauge,
+		queueJobsGauge,
+		requestsTotal,
+		requestErrorsTotal,
+		requestDuration,
+		redisCallDuration,
+		redisErrorsTotal,
+		lengthCacheLookups,
+		sharedReads,
+		redisCircuitOpenGauge,
+		redisFailoversTotal,
+	)
+}
+
+// observeRequest counts a gRPC call and, if it failed, its error
+func observeRequest(method string, err error) {
+	requestsTotal.WithLabelValues(method).Inc()
+	if err != nil {
+		requestErrorsTotal.WithLabelValues(method).Inc()
+	}
+}
+
+// observeRedisCall records the latency of a Redis command started at start and, if it
+// failed, the kind of failure. redis.Nil only reports a missing key and is not counted.
+func observeRedisCall(command string, start time.Time, err error) {
+	redisCallDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	switch {
+	case err == nil || errors.Is(err, redis.Nil):
+	case isTimeoutError(err):
+		redisErrorsTotal.WithLabelValues(command, "timeout").Inc()
+	case isRedisReplyError(err):
+		redisErrorsTotal.WithLabelValues(command, "reply").Inc()
+	default:
+		redisErrorsTotal.WithLabelValues(command, "connection").Inc()
+	}
+}
+
+// recordQueueLengths publishes the last observed queue lengths for a ScaledObject, in
+// total and per queue
+func recordQueueLengths(namespace, name string, keys queueKeys, lengths queueLengths) {
+	queueJobsGauge.WithLabelValues(namespace, name, "wait").Set(float64(lengths.wait))
+	queueJobsGauge.WithLabelValues(namespace, name, "active").Set(float64(lengths.active))
+	queueJobsGauge.WithLabelValues(namespace, name, "delayed").Set(float64(lengths.delayed))
+	queueJobsGauge.WithLabelValues(namespace, name, "prioritized").Set(float64(lengths.prioritized))
+	queueJobsGauge.WithLabelValues(namespace, name, "stalled").Set(float64(lengths.stalled))
+	queueJobsGauge.WithLabelValues(namespace, name, "waiting_children").Set(float64(lengths.waitingChildren))
+	queueJobsGauge.WithLabelValues(namespace, name, "grouped").Set(float64(lengths.grouped))
+	queueJobsGauge.WithLabelValues(namespace, name, "total").Set(float64(lengths.total()))
+	recordPerQueueLengths(namespace, name, keys, lengths)
+}
+
+// perQueueSeries remembers the queues each ScaledObject was last exported with, so the
+// series of queues it no longer reads are removed rather than left at their last value
+var perQueueSeries = struct {
+	sync.Mutex
+	queues map[string]string
+}{queues: make(map[string]string)}
+
+// recordPerQueueLengths sets the per-queue gauges. Queues are named after their wait list,
+// e.g. "bull:emails", and lists found by waitListPattern are reported together under the
+// pattern.
+func recordPerQueueLengths(namespace, name string, keys queueKeys, lengths queueLengths) {
+	queues := make([]string, len(keys.wait))
+	for i, waitList := range keys.wait {
+		queues[i] = strings.TrimSuffix(jobKeyPrefix(waitList), ":")
+	}
+	if keys.waitPattern != "" {
+		queues = append(queues, keys.waitPattern)
+	}
+
+	id := namespace + "/" + name
+	perQueueSeries.Lock()
+	if joined := strings.Join(queues, ","); perQueueSeries.queues[id] != joined {
+		previous := prometheus.Labels{"namespace": namespace, "scaled_object": name}
+		waitJobsGauge.DeletePartialMatch(previous)
+		activeJobsGauge.DeletePartialMatch(previous)
+		delayedJobsGauge.DeletePartialMatch(previous)
+		perQueueSeries.queues[id] = joined
+	}
+	perQueueSeries.Unlock()
+
+	var listed int64
+	for i, queue := range queues[:len(keys.wait)] {
+		q := lengths.queue(i)
+		listed += q.wait + q.prioritized
+		waitJobsGauge.WithLabelValues(namespace, name, queue).Set(float64(q.wait + q.prioritized))
+		activeJobsGauge.WithLabelValues(namespace, name, queue).Set(float64(q.active))
+		if len(keys.delayed) > 0 {
+			delayedJobsGauge.WithLabelValues(namespace, name, queue).Set(float64(q.delayed))
+		}
+	}
+	if keys.waitPattern != "" {
+		waitJobsGauge.WithLabelValues(namespace, name, keys.waitPattern).Set(float64(lengths.wait + lengths.prioritized - listed))
+	}
+}
+
+// startMetricsServer serves Prometheus metrics on /metrics in the background.
+// A failure to bind is logged as a warning so it never takes down the scaler.
+func startMetricsServer(port string) {
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		slog.Warn("Failed to start metrics server", "port", port, "error", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		slog.Info("Serving Prometheus metrics on /metrics", "port", port)
+		if err := http.Serve(lis, mux); err != nil {
+			slog.Warn("Metrics server stopped", "error", err)
+		}
+	}()
+}
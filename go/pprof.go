@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// startPprofServer serves the net/http/pprof profiles under /debug/pprof/ in the background.
+// It has its own port and mux rather than the default one, so profiles are only reachable
+// where the port is exposed. A failure to bind is logged as a warning so it never takes
+// down the scaler.
+func startPprofServer(port string) {
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		slog.Warn("Failed to start pprof server", "port", port, "error", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		slog.Info("Serving pprof profiles on /debug/pprof/", "port", port)
+		if err := http.Serve(lis, mux); err != nil {
+			slog.Warn("pprof server stopped", "error", err)
+		}
+	}()
+}
@@ -0,0 +1,887 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// queueKeys identifies the Redis keys whose jobs are summed into one metric. Several
+// keys of each kind may be given to aggregate multiple queues.
+type queueKeys struct {
+	wait        []string
+	active      []string
+	delayed     []string // optional sorted sets of delayed jobs
+	delayedDue  bool     // count only delayed jobs whose due time has passed
+	prioritized []string // optional sorted sets of prioritized jobs (BullMQ v4+)
+	stalled     []string // optional sets (lists in older BullMQ) of potentially stalled jobs
+
+	// countDelayed adds the delayed jobs to the total, by default for an explicit delayedSet
+	// and with includeDelayed "all" or "due" for the one derived from queueName. Otherwise
+	// they are only read for the delayed gauge and metric.
+	countDelayed bool
+
+	// lookahead also counts the delayed jobs due within it, such as the next run of a
+	// repeatable job, to activate before they fire
+	lookahead time.Duration
+
+	// waitingChildren are the optional sorted sets of flow parents waiting for their children
+	waitingChildren []string
+
+	// groups are the optional BullMQ Pro sorted sets of group IDs, whose grouped jobs wait
+	// outside the wait list; groupConcurrency caps the jobs counted per group when positive
+	groups           []string
+	groupConcurrency int64
+
+	// excludeMarkers discounts BullMQ marker entries ("0:<timestamp>") from short wait lists
+	excludeMarkers bool
+
+	// nameFilter counts only the wait, active and prioritized jobs with matching names
+	nameFilter jobNameFilter
+
+	// atomic counts the keys with a Lua script instead of a pipeline, for a consistent snapshot
+	atomic bool
+
+	// waitPattern is an optional glob; the lengths of all lists matching it are added to wait
+	waitPattern string
+
+	// paused is the optional key that marks the queue paused
+	paused string
+
+	// completed and failed are the optional sorted sets of finished jobs, counted to derive
+	// the completion rate
+	completed []string
+	failed    []string
+
+	// redis selects a Redis instance other than the default one
+	redis redisOverride
+}
+
+// id returns a stable identifier for the key set, used to deduplicate reads
+func (k queueKeys) id() string {
+	return strings.Join([]string{k.redis.id(), k.waitPattern, strconv.FormatBool(k.delayedDue), strconv.FormatBool(k.countDelayed), strconv.FormatBool(k.excludeMarkers), strconv.FormatBool(k.atomic), strings.Join(k.wait, ","), strings.Join(k.active, ","), strings.Join(k.delayed, ","), strings.Join(k.prioritized, ","), strings.Join(k.stalled, ","), strings.Join(k.waitingChildren, ","), strings.Join(k.groups, ","), strconv.FormatInt(k.groupConcurrency, 10), k.paused, strings.Join(k.completed, ","), strings.Join(k.failed, ","), k.nameFilter.id(), k.lookahead.String()}, "\x00")
+}
+
+// pausedLists returns the list each wait list is renamed to while the queue is paused, as
+// BullMQ moves the waiting jobs aside on pause, or "" for a wait list not named "...wait".
+// It is nil when the pause is not checked.
+func (k queueKeys) pausedLists() []string {
+	if k.paused == "" {
+		return nil
+	}
+	lists := make([]string, len(k.wait))
+	for i, key := range k.wait {
+		if strings.HasSuffix(key, "wait") {
+			lists[i] = jobKeyPrefix(key) + "paused"
+		}
+	}
+	return lists
+}
+
+// name identifies the queue in per-queue state and log lines. It is the wait list key, or
+// the comma-joined wait list keys when several queues are aggregated, followed by the wait
+// list pattern if one is set.
+func (k queueKeys) name() string {
+	if k.waitPattern == "" {
+		return strings.Join(k.wait, ",")
+	}
+	return strings.Join(append(slices.Clone(k.wait), k.waitPattern), ",")
+}
+
+// logAttrs returns the keys as structured log attributes
+func (k queueKeys) logAttrs() []any {
+	return []any{
+		"waitList", strings.Join(k.wait, ","),
+		"waitListPattern", k.waitPattern,
+		"activeList", strings.Join(k.active, ","),
+		"delayedSet", strings.Join(k.delayed, ","),
+		"prioritizedSet", strings.Join(k.prioritized, ","),
+		"stalledSet", strings.Join(k.stalled, ","),
+		"waitingChildrenSet", strings.Join(k.waitingChildren, ","),
+		"groupsKey", strings.Join(k.groups, ","),
+	}
+}
+
+// queueLengths holds the result of a single read of a queue's keys
+type queueLengths struct {
+	wait        int64
+	active      int64
+	delayed     int64
+	prioritized int64
+	stalled     int64
+
+	waitingChildren int64
+	grouped         int64 // waiting jobs of BullMQ Pro groups
+
+	// delayedCounted reports whether delayed is part of the total, see queueKeys.countDelayed
+	delayedCounted bool
+
+	// upcoming is the number of delayed jobs due within scheduleLookahead, those already due
+	// included. It is not part of the total.
+	upcoming int64
+
+	// queues holds the counts of each aggregated queue, one per wait list
+	queues []queueLengths
+
+	// paused reports whether pausedKey marks the queue paused
+	paused bool
+
+	// finished is the number of jobs in the completed and failed sets, and failed the part
+	// of it in the failed sets. They are informational, so a failure to count them is kept
+	// in finishedErr instead of failing the read.
+	finished    int64
+	failed      int64
+	finishedErr error
+}
+
+// total returns the number of jobs across every configured key, the delayed sets only
+// when they are counted
+func (l queueLengths) total() int64 {
+	return l.wait + l.active + l.countedDelayed() + l.prioritized + l.stalled + l.waitingChildren + l.grouped
+}
+
+// countedDelayed returns the delayed jobs that count toward the total
+func (l queueLengths) countedDelayed() int64 {
+	if !l.delayedCounted {
+		return 0
+	}
+	return l.delayed
+}
+
+// setDelayedCounted records whether delayed jobs count, for the per-queue counts too
+func (l *queueLengths) setDelayedCounted(counted bool) {
+	l.delayedCounted = counted
+	for i := range l.queues {
+		l.queues[i].delayedCounted = counted
+	}
+}
+
+// queueWeights scales how much each kind of job contributes to the GetMetrics value
+type queueWeights struct {
+	wait    float64
+	active  float64
+	delayed float64
+}
+
+// defaultQueueWeights counts every job once, giving the plain total
+var defaultQueueWeights = queueWeights{wait: 1, active: 1, delayed: 1}
+
+// weightedTotal returns the weighted sum of the lengths rounded to the nearest integer
+func (l queueLengths) weightedTotal(w queueWeights) int64 {
+	return int64(math.Round(l.weightedSum(w)))
+}
+
+// weightedSum returns the weighted sum of the lengths. Prioritized, grouped jobs and flow
+// parents are waiting to run, so they use the wait weight, and stalled jobs were active when
+// their worker died, so they use the active weight.
+func (l queueLengths) weightedSum(w queueWeights) float64 {
+	return float64(l.wait+l.prioritized+l.waitingChildren+l.grouped)*w.wait +
+		float64(l.active+l.stalled)*w.active +
+		float64(l.countedDelayed())*w.delayed
+}
+
+// checkQueuesAligned reports an error unless every key list has one key per wait list, so
+// the i-th keys of each kind belong to the same queue, as they do when derived from
+// queueName. Keys found by waitListPattern cannot be attributed to a queue.
+func checkQueuesAligned(k queueKeys) error {
+	if k.waitPattern != "" {
+		return fmt.Errorf("aggregation max cannot be combined with waitListPattern")
+	}
+	lists := map[string][]string{"activeList": k.active, "delayedSet": k.delayed, "prioritizedSet": k.prioritized, "stalledSet": k.stalled, "waitingChildrenSet": k.waitingChildren, "groupsKey": k.groups}
+	for field, keys := range lists {
+		if len(keys) > 0 && len(keys) != len(k.wait) {
+			return fmt.Errorf("aggregation max needs one %s per waitList, got %d for %d", field, len(keys), len(k.wait))
+		}
+	}
+	return nil
+}
+
+// queue returns the counts of the i-th aggregated queue. Per-queue counts pair the i-th
+// key of each kind, as derived from queueName, so keys past the number of wait lists only
+// count toward the totals and get a throwaway value.
+func (l *queueLengths) queue(i int) *queueLengths {
+	if i < len(l.queues) {
+		return &l.queues[i]
+	}
+	return &queueLengths{}
+}
+
+// maxQueueTotal returns the largest weighted total of any single aggregated queue
+func (l queueLengths) maxQueueTotal(w queueWeights) int64 {
+	var largest int64
+	for _, q := range l.queues {
+		largest = max(largest, q.weightedTotal(w))
+	}
+	return largest
+}
+
+// logAttrs returns the per-key counts as structured log attributes
+func (l queueLengths) logAttrs() []any {
+	return []any{"waitLen", l.wait, "activeLen", l.active, "delayedLen", l.delayed, "prioritizedLen", l.prioritized, "stalledLen", l.stalled, "waitingChildrenLen", l.waitingChildren, "groupedLen", l.grouped}
+}
+
+// readQueueLengths returns the lengths of the queue's keys. A read younger than
+// METRIC_CACHE_TTL is reused, or with REDIS_CLIENT_TRACKING one whose keys have not changed
+// since, and when singleflight is enabled, concurrent calls for the same key set share a
+// single Redis round trip, keyed by the connection and keys so only identical reads are
+// shared. Until the default client has connected after startup, or while the circuit
+// breaker of the endpoint is open, reads fail fast with Unavailable.
+func (s *server) readQueueLengths(ctx context.Context, keys queueKeys) (queueLengths, error) {
+	if keys.redis.host == "" {
+		if err := s.redisConn.err(); err != nil {
+			return queueLengths{}, err
+		}
+	}
+	id := keys.id()
+	if lengths, ok := s.lengthCache.get(id); ok {
+		slog.Debug("Queue lengths served from cache", keys.logAttrs()...)
+		return lengths, nil
+	}
+	tracked := keys.trackedKeys()
+	if tracked != nil {
+		if lengths, ok := s.tracking.get(id); ok {
+			slog.Debug("Queue lengths served from client tracking cache", keys.logAttrs()...)
+			return lengths, nil
+		}
+	}
+
+	breaker := s.breakers.get(keys.redis)
+	if err := breaker.allow(time.Now()); err != nil {
+		return queueLengths{}, err
+	}
+
+	fetch := func(ctx context.Context) (queueLengths, error) {
+		slog.Debug("Reading queue lengths from Redis", keys.logAttrs()...)
+		seq := s.tracking.begin(tracked)
+		lengths, err := s.fetchQueueLengthsWithRetry(ctx, keys)
+		lengths.setDelayedCounted(keys.countDelayed)
+		breaker.record(err, time.Now())
+		if err == nil {
+			s.lengthCache.put(id, lengths)
+			if tracked != nil {
+				s.tracking.put(id, tracked, lengths, seq)
+			}
+		}
+		return lengths, err
+	}
+	if !s.dedupeReads {
+		return fetch(ctx)
+	}
+
+	// Each caller stops waiting when its own context ends, while the shared read runs on
+	// in its own goroutine for the others
+	ch := s.readGroup.DoChan(id, func() (result interface{}, err error) {
+		// DoChan re-raises a panic in a new goroutine, where the recovery interceptor cannot
+		// catch it, so it is returned as an error instead
+		defer func() {
+			if r := recover(); r != nil {
+				slog.Error("Recovered from panic reading queue lengths", append(keys.logAttrs(), "panic", r, "stack", string(debug.Stack()))...)
+				result, err = queueLengths{}, status.Errorf(codes.Internal, "internal error reading queue lengths: %v", r)
+			}
+		}()
+		// The read keeps the deadline of the call that started it but not its cancellation,
+		// so KEDA abandoning that call does not fail the others waiting on it. The flight
+		// owns the deadline, so it lasts as long as the read.
+		sharedCtx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			sharedCtx, cancel = context.WithDeadline(sharedCtx, deadline)
+			defer cancel()
+		}
+		return fetch(sharedCtx)
+	})
+	select {
+	case result := <-ch:
+		if result.Shared {
+			sharedReads.Inc()
+			slog.Debug("Shared in-flight queue read", keys.logAttrs()...)
+		}
+		return result.Val.(queueLengths), result.Err
+	case <-ctx.Done():
+		return queueLengths{}, ctx.Err()
+	}
+}
+
+// parseKeyList splits a comma-separated metadata value into trimmed, non-empty keys
+func parseKeyList(value string) []string {
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// defaultQueuePrefix is BullMQ's default key prefix
+const defaultQueuePrefix = "bull"
+
+// resolveQueueKeys builds the key set from trigger metadata. Explicit waitList, activeList,
+// delayedSet and prioritizedSet keys win; any that are missing are derived from queueName
+// (comma-separated for several queues) following BullMQ's "prefix:queueName:type" scheme.
+// With queueHashTag the queue name is wrapped in braces, as BullMQ does for Redis Cluster.
+func resolveQueueKeys(namespace string, metadata map[string]string) (queueKeys, error) {
+	keys := queueKeys{
+		wait:        parseKeyList(metadata["waitList"]),
+		active:      parseKeyList(metadata["activeList"]),
+		delayed:     parseKeyList(metadata["delayedSet"]),
+		prioritized: parseKeyList(metadata["prioritizedSet"]),
+		stalled:     parseKeyList(metadata["stalledSet"]),
+
+		waitingChildren: parseKeyList(metadata["waitingChildrenSet"]),
+		paused:          metadata["pausedKey"],
+		completed:       parseKeyList(metadata["completedSet"]),
+		failed:          parseKeyList(metadata["failedSet"]),
+	}
+	patternKey, err := metadataKey(metadata, "waitListPattern")
+	if err != nil {
+		return queueKeys{}, err
+	}
+	keys.waitPattern = strings.TrimSpace(metadata[patternKey])
+	if keys.redis, err = parseRedisOverride(namespace, metadata); err != nil {
+		return queueKeys{}, err
+	}
+	if keys.excludeMarkers, err = getMetadataBool(metadata, "excludeMarkers", false); err != nil {
+		return queueKeys{}, err
+	}
+	if keys.atomic, err = getMetadataBool(metadata, "atomicRead", false); err != nil {
+		return queueKeys{}, err
+	}
+	// The keys a pattern matches are only known after a SCAN, outside any script
+	if keys.atomic && keys.waitPattern != "" {
+		return queueKeys{}, fmt.Errorf("atomicRead cannot be combined with waitListPattern")
+	}
+	if keys.nameFilter, err = parseJobNameFilter(metadata, keys); err != nil {
+		return queueKeys{}, err
+	}
+	// The jobs of an explicit delayedSet count by default, since naming the set asks for
+	// them. Those of the set derived from queueName are informational unless
+	// includeDelayed opts in to counting them.
+	switch mode := metadata["includeDelayed"]; mode {
+	case "":
+		keys.countDelayed = len(keys.delayed) > 0
+	case "none":
+	case "all":
+		keys.countDelayed = true
+	case "due":
+		keys.countDelayed, keys.delayedDue = true, true
+	default:
+		return queueKeys{}, fmt.Errorf("includeDelayed must be \"none\", \"all\" or \"due\", got: %s", mode)
+	}
+	if keys.lookahead, err = getMetadataDuration(metadata, "scheduleLookahead", 0); err != nil {
+		return queueKeys{}, err
+	}
+	if keys.lookahead > 0 && keys.atomic {
+		return queueKeys{}, fmt.Errorf("scheduleLookahead cannot be combined with atomicRead")
+	}
+
+	nameKey, err := metadataKey(metadata, "queueName")
+	if err != nil {
+		return queueKeys{}, err
+	}
+	names := parseKeyList(metadata[nameKey])
+
+	// The pause check runs whenever pausedKey is set, unless respectPause turns it off.
+	// When pausedKey is not given, respectPause derives the meta hash from the queue.
+	respectPause, err := getMetadataBool(metadata, "respectPause", keys.paused != "")
+	if err != nil {
+		return queueKeys{}, err
+	}
+	if !respectPause {
+		keys.paused = ""
+	}
+
+	layout, err := parseKeyLayout(metadata)
+	if err != nil {
+		return queueKeys{}, err
+	}
+
+	// BullMQ v4+ keeps jobs added with a priority in a separate sorted set, so it is counted
+	// by default when keys are derived from queueName, and on request with raw keys
+	includePrioritized, err := getMetadataBool(metadata, "includePrioritized", len(names) > 0 && layout.prioritizedSet)
+	if err != nil {
+		return queueKeys{}, err
+	}
+	if includePrioritized && !layout.prioritizedSet {
+		return queueKeys{}, fmt.Errorf("includePrioritized requires BullMQ 4 or later, which keeps prioritized jobs in their own set")
+	}
+
+	// Parents added with FlowProducer wait in this set until their children finish. They
+	// are not runnable yet, so they are only counted on request.
+	includeWaitingChildren, err := getMetadataBool(metadata, "includeWaitingChildren", false)
+	if err != nil {
+		return queueKeys{}, err
+	}
+	// BullMQ Pro keeps jobs added with a group ID out of the wait list, so a queue using
+	// groups reads as empty unless they are counted
+	groupsEnabled, err := getMetadataBool(metadata, "groupsEnabled", false)
+	if err != nil {
+		return queueKeys{}, err
+	}
+	if keys.groupConcurrency, err = getMetadataNonNegativeInt(metadata, "groupConcurrency", 0); err != nil {
+		return queueKeys{}, err
+	}
+	if keys.groupConcurrency > 0 && !groupsEnabled {
+		return queueKeys{}, fmt.Errorf("groupConcurrency requires groupsEnabled")
+	}
+	// Group IDs are read with their own command, outside any script
+	if groupsEnabled && keys.atomic {
+		return queueKeys{}, fmt.Errorf("atomicRead cannot be combined with groupsEnabled")
+	}
+	// BullMQ keeps the jobs its stalled checker is watching in this set. A pile-up points
+	// to crashed workers, so counting it is opt-in for derived keys.
+	includeStalled, err := getMetadataBool(metadata, "includeStalled", false)
+	if err != nil {
+		return queueKeys{}, err
+	}
+
+	if len(names) == 0 {
+		// A pattern stands in for both lists, since dynamically created queues cannot be
+		// enumerated in metadata
+		var missing []string
+		if keys.waitPattern == "" && len(keys.wait) == 0 {
+			missing = append(missing, "waitList")
+		}
+		if keys.waitPattern == "" && len(keys.active) == 0 {
+			missing = append(missing, "activeList")
+		}
+		if len(missing) > 0 {
+			return queueKeys{}, fmt.Errorf("required metadata %s missing or empty (set queueName, waitListPattern, or both waitList and activeList)", strings.Join(missing, " and "))
+		}
+		// The prioritized set sits next to the wait list: "bull:q:wait" -> "bull:q:prioritized"
+		if includePrioritized && len(keys.prioritized) == 0 {
+			for _, waitList := range keys.wait {
+				keys.prioritized = append(keys.prioritized, jobKeyPrefix(waitList)+"prioritized")
+			}
+		}
+		if groupsEnabled {
+			for _, waitList := range keys.wait {
+				keys.groups = append(keys.groups, jobKeyPrefix(waitList)+"groups")
+			}
+		}
+		if includeStalled && len(keys.stalled) == 0 {
+			for _, waitList := range keys.wait {
+				keys.stalled = append(keys.stalled, jobKeyPrefix(waitList)+"stalled")
+			}
+		}
+		if includeWaitingChildren && len(keys.waitingChildren) == 0 {
+			for _, waitList := range keys.wait {
+				keys.waitingChildren = append(keys.waitingChildren, jobKeyPrefix(waitList)+"waiting-children")
+			}
+		}
+		if keys.paused == "" && respectPause {
+			if len(keys.wait) != 1 {
+				return queueKeys{}, fmt.Errorf("respectPause requires pausedKey unless exactly one waitList or queueName is set")
+			}
+			keys.paused = jobKeyPrefix(keys.wait[0]) + layout.pausedSuffix
+		}
+		return keys, nil
+	}
+
+	hashTag, err := getMetadataBool(metadata, "queueHashTag", false)
+	if err != nil {
+		return queueKeys{}, err
+	}
+	prefixKey, err := metadataKey(metadata, "queuePrefix")
+	if err != nil {
+		return queueKeys{}, err
+	}
+	prefix := getMetadataDefault(metadata, prefixKey, defaultQueuePrefix)
+	derive := func(suffix string) []string {
+		derived := make([]string, 0, len(names))
+		for _, name := range names {
+			if hashTag {
+				name = "{" + name + "}"
+			}
+			derived = append(derived, prefix+":"+name+":"+suffix)
+		}
+		return derived
+	}
+
+	if len(keys.wait) == 0 {
+		keys.wait = derive("wait")
+	}
+	if len(keys.active) == 0 {
+		keys.active = derive("active")
+	}
+	if len(keys.delayed) == 0 {
+		keys.delayed = derive("delayed")
+	}
+	if len(keys.prioritized) == 0 && includePrioritized {
+		keys.prioritized = derive("prioritized")
+	}
+	if groupsEnabled {
+		keys.groups = derive("groups")
+	}
+	if len(keys.stalled) == 0 && includeStalled {
+		keys.stalled = derive("stalled")
+	}
+	if len(keys.waitingChildren) == 0 && includeWaitingChildren {
+		keys.waitingChildren = derive("waiting-children")
+	}
+	// BullMQ records the paused state in the queue's meta hash. Several queues have several
+	// meta hashes, so aggregated queues need an explicit pausedKey.
+	if keys.paused == "" && respectPause {
+		if len(names) > 1 {
+			return queueKeys{}, fmt.Errorf("respectPause requires pausedKey unless exactly one waitList or queueName is set")
+		}
+		keys.paused = derive(layout.pausedSuffix)[0]
+	}
+	return keys, nil
+}
+
+// keyLayout describes how a queue library version lays out its keys, where that matters
+// for which keys are derived
+type keyLayout struct {
+	// prioritizedSet reports whether prioritized jobs wait in their own sorted set rather
+	// than in the wait list
+	prioritizedSet bool
+	// pausedSuffix names the key that marks the queue paused: a field of BullMQ's meta hash,
+	// or a standalone key that legacy Bull creates while the queue is paused
+	pausedSuffix string
+}
+
+// parseKeyLayout reads the library and bullmqVersion metadata. library is "bullmq" (the
+// default) or "bull" for legacy Bull v3; bullmqVersion is the BullMQ major version, and
+// 0 or absent means a current one.
+func parseKeyLayout(metadata map[string]string) (keyLayout, error) {
+	version, err := getMetadataNonNegativeInt(metadata, "bullmqVersion", 0)
+	if err != nil {
+		return keyLayout{}, err
+	}
+	switch library := metadata["library"]; library {
+	case "", "bullmq":
+		return keyLayout{prioritizedSet: version == 0 || version >= 4, pausedSuffix: "meta"}, nil
+	case "bull":
+		if version != 0 {
+			return keyLayout{}, fmt.Errorf("bullmqVersion cannot be combined with library bull")
+		}
+		return keyLayout{pausedSuffix: "meta-paused"}, nil
+	default:
+		return keyLayout{}, fmt.Errorf("library must be \"bullmq\" or \"bull\", got: %s", library)
+	}
+}
+
+// fetchQueueLengthsWithRetry retries a failed read once after a Ping confirms Redis is
+// reachable again, so connections broken by a Redis restart are replaced instead of
+// failing every call. Error replies such as WRONGTYPE are returned without a retry.
+func (s *server) fetchQueueLengthsWithRetry(ctx context.Context, keys queueKeys) (queueLengths, error) {
+	client := s.clientFor(keys.redis)
+	lengths, err := s.fetchQueueLengths(ctx, client, keys)
+	if err == nil || isRedisReplyError(err) || status.Code(err) == codes.InvalidArgument || ctx.Err() != nil {
+		return lengths, err
+	}
+
+	pingErr := s.redisOp(ctx, "ping", func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	})
+	if pingErr != nil {
+		slog.Warn("Queue read failed and Redis is still unreachable", "error", err, "pingError", pingErr)
+		return queueLengths{}, err
+	}
+
+	slog.Warn("Queue read failed, retrying once after a successful Ping", "error", err)
+	return s.fetchQueueLengths(ctx, client, keys)
+}
+
+// fetchQueueLengths reads every configured key in a single pipelined round trip: LLEN for
+// each wait and active list, ZCARD for each delayed, prioritized and waiting-children set, SCARD for each
+// stalled set, HEXISTS for the paused flag and ZCARD for the completed and failed sets.
+// With includeDelayed "due", delayed sets are counted with a ZCOUNT of the jobs due by now
+// instead, and with scheduleLookahead the jobs due within it are counted with another.
+// Only unusual key types, such as a stalled list, cost a follow-up command.
+func (s *server) fetchQueueLengths(ctx context.Context, client redisCmdable, keys queueKeys) (queueLengths, error) {
+	if keys.atomic {
+		return s.fetchQueueLengthsAtomic(ctx, client, keys)
+	}
+	var lengths queueLengths
+	now := time.Now()
+	dueMax := delayedDueMax(now)
+
+	var waitCmds, activeCmds, delayedCmds, upcomingCmds, prioritizedCmds, stalledCmds, waitingChildrenCmds, finishedCmds []*redis.IntCmd
+	var pausedCmd *redis.BoolCmd
+	pausedLists := keys.pausedLists()
+	pausedListCmds := make([]*redis.IntCmd, len(pausedLists))
+	pipeErr := s.redisOp(ctx, "queue_pipeline", func(ctx context.Context) error {
+		pipe := client.Pipeline()
+		for _, key := range keys.wait {
+			waitCmds = append(waitCmds, pipe.LLen(ctx, key))
+		}
+		for _, key := range keys.active {
+			activeCmds = append(activeCmds, pipe.LLen(ctx, key))
+		}
+		for _, key := range keys.delayed {
+			if keys.delayedDue {
+				delayedCmds = append(delayedCmds, pipe.ZCount(ctx, key, "-inf", dueMax))
+			} else {
+				delayedCmds = append(delayedCmds, pipe.ZCard(ctx, key))
+			}
+			if keys.lookahead > 0 {
+				upcomingCmds = append(upcomingCmds, pipe.ZCount(ctx, key, "-inf", delayedDueMax(now.Add(keys.lookahead))))
+			}
+		}
+		for _, key := range keys.prioritized {
+			prioritizedCmds = append(prioritizedCmds, pipe.ZCard(ctx, key))
+		}
+		for _, key := range keys.stalled {
+			stalledCmds = append(stalledCmds, pipe.SCard(ctx, key))
+		}
+		for _, key := range keys.waitingChildren {
+			waitingChildrenCmds = append(waitingChildrenCmds, pipe.ZCard(ctx, key))
+		}
+		if keys.paused != "" {
+			pausedCmd = pipe.HExists(ctx, keys.paused, "paused")
+		}
+		for i, key := range pausedLists {
+			if key != "" {
+				pausedListCmds[i] = pipe.LLen(ctx, key)
+			}
+		}
+		for _, key := range append(slices.Clone(keys.completed), keys.failed...) {
+			finishedCmds = append(finishedCmds, pipe.ZCard(ctx, key))
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+
+	lengths.queues = make([]queueLengths, len(keys.wait))
+
+	// With jobNameFilter, the jobs of the wait, active and prioritized keys are counted by
+	// name instead. Keys whose read failed are left to the checks below.
+	var named map[string]int64
+	if len(keys.nameFilter.patterns) > 0 {
+		var sources []namedJobSource
+		for i, cmd := range waitCmds {
+			sources = append(sources, namedJobSource{key: keys.wait[i], total: cmd.Val()})
+		}
+		for i, cmd := range activeCmds {
+			sources = append(sources, namedJobSource{key: keys.active[i], total: cmd.Val()})
+		}
+		for i, cmd := range prioritizedCmds {
+			sources = append(sources, namedJobSource{key: keys.prioritized[i], sorted: true, total: cmd.Val()})
+		}
+		for i, cmd := range pausedListCmds {
+			if cmd != nil {
+				sources = append(sources, namedJobSource{key: pausedLists[i], total: cmd.Val()})
+			}
+		}
+		var err error
+		if named, err = s.countNamedJobs(ctx, client, keys.nameFilter, sources); err != nil {
+			return queueLengths{}, err
+		}
+	}
+	namedCount := func(key string, count int64) int64 {
+		if named != nil {
+			return named[key]
+		}
+		return count
+	}
+
+	// Check each command so the error names the key that failed
+	for i, cmd := range waitCmds {
+		if err := cmd.Err(); err != nil {
+			return queueLengths{}, s.keyReadError("waitList", keys.wait[i], "list", err)
+		}
+		waitLen := namedCount(keys.wait[i], cmd.Val())
+		if named == nil && keys.excludeMarkers && waitLen > 0 && waitLen <= markerScanLimit {
+			markers, err := s.countMarkers(ctx, client, keys.wait[i])
+			if err != nil {
+				return queueLengths{}, err
+			}
+			waitLen -= markers
+		}
+		lengths.wait += waitLen
+		lengths.queue(i).wait += waitLen
+	}
+	for i, cmd := range activeCmds {
+		if err := cmd.Err(); err != nil {
+			return queueLengths{}, s.keyReadError("activeList", keys.active[i], "list", err)
+		}
+		active := namedCount(keys.active[i], cmd.Val())
+		lengths.active += active
+		lengths.queue(i).active += active
+	}
+	for i, cmd := range delayedCmds {
+		if err := cmd.Err(); err != nil {
+			return queueLengths{}, s.keyReadError("delayedSet", keys.delayed[i], "sorted set", err)
+		}
+		lengths.delayed += cmd.Val()
+		lengths.queue(i).delayed += cmd.Val()
+	}
+	// A set that could not be read already failed with its delayed count above
+	for _, cmd := range upcomingCmds {
+		if err := cmd.Err(); err == nil {
+			lengths.upcoming += cmd.Val()
+		}
+	}
+	for i, cmd := range prioritizedCmds {
+		if err := cmd.Err(); err != nil {
+			return queueLengths{}, s.keyReadError("prioritizedSet", keys.prioritized[i], "sorted set", err)
+		}
+		prioritized := namedCount(keys.prioritized[i], cmd.Val())
+		lengths.prioritized += prioritized
+		lengths.queue(i).prioritized += prioritized
+	}
+	for i, cmd := range stalledCmds {
+		count, err := cmd.Result()
+		if err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE") {
+			// Older BullMQ versions keep stalled jobs in a list
+			err = s.redisOp(ctx, "llen", func(ctx context.Context) (err error) {
+				count, err = client.LLen(ctx, keys.stalled[i]).Result()
+				return err
+			})
+		}
+		if err != nil {
+			return queueLengths{}, s.keyReadError("stalledSet", keys.stalled[i], "set or list", err)
+		}
+		lengths.stalled += count
+		lengths.queue(i).stalled += count
+	}
+	for i, cmd := range waitingChildrenCmds {
+		if err := cmd.Err(); err != nil {
+			return queueLengths{}, s.keyReadError("waitingChildrenSet", keys.waitingChildren[i], "sorted set", err)
+		}
+		lengths.waitingChildren += cmd.Val()
+		lengths.queue(i).waitingChildren += cmd.Val()
+	}
+	if pausedCmd != nil {
+		paused, err := s.pausedResult(ctx, client, keys.paused, pausedCmd)
+		if err != nil {
+			return queueLengths{}, err
+		}
+		lengths.paused = paused
+	}
+	// While paused, BullMQ keeps the waiting jobs in the paused list instead of the wait list
+	if lengths.paused {
+		for i, cmd := range pausedListCmds {
+			if cmd == nil {
+				continue
+			}
+			if err := cmd.Err(); err != nil {
+				return queueLengths{}, s.keyReadError("waitList", pausedLists[i], "list", err)
+			}
+			waiting := namedCount(pausedLists[i], cmd.Val())
+			lengths.wait += waiting
+			lengths.queue(i).wait += waiting
+		}
+	}
+	for i, cmd := range finishedCmds {
+		if err := cmd.Err(); err != nil {
+			field, key := "completedSet", ""
+			if i < len(keys.completed) {
+				key = keys.completed[i]
+			} else {
+				field, key = "failedSet", keys.failed[i-len(keys.completed)]
+			}
+			lengths.finishedErr = s.keyReadError(field, key, "sorted set", err)
+			break
+		}
+		lengths.finished += cmd.Val()
+		if i >= len(keys.completed) {
+			lengths.failed += cmd.Val()
+		}
+	}
+	// Every command's error was checked above; error replies handled there, such as a
+	// stalled list, must not fail the whole read
+	if pipeErr != nil && !isRedisReplyError(pipeErr) {
+		return queueLengths{}, fmt.Errorf("failed to read queue keys: %w", pipeErr)
+	}
+
+	if keys.waitPattern != "" {
+		patternWait, err := s.readPatternWaitLength(ctx, client, keys)
+		if err != nil {
+			return queueLengths{}, err
+		}
+		lengths.wait += patternWait
+	}
+	for i, groupsKey := range keys.groups {
+		grouped, err := s.readGroupedWaitLength(ctx, client, keys, groupsKey)
+		if err != nil {
+			return queueLengths{}, err
+		}
+		lengths.grouped += grouped
+		lengths.queue(i).grouped += grouped
+	}
+
+	return lengths, nil
+}
+
+// delayedDueMax returns the exclusive ZCOUNT bound for delayed jobs due by now. Delayed
+// scores are the due time in ms shifted left for a counter, so every job due at or before
+// now scores below the first score of the next millisecond.
+func delayedDueMax(now time.Time) string {
+	return "(" + strconv.FormatInt((now.UnixMilli()+1)*delayedScoreShift, 10)
+}
+
+// markerScanLimit is the longest wait list whose entries are inspected for markers. A
+// marker only changes the scaling decision when the list is nearly empty, so longer lists
+// are counted as they are rather than paying for an LRANGE on every poll.
+const markerScanLimit = 10
+
+// countMarkers returns the number of BullMQ marker entries in a wait list. Some BullMQ
+// versions push a "0:<timestamp>" marker into the wait list to wake blocked workers, so an
+// empty queue still has an LLEN of 1. Job IDs do not normally take that form.
+func (s *server) countMarkers(ctx context.Context, client redisCmdable, waitList string) (int64, error) {
+	var entries []string
+	err := s.redisOp(ctx, "lrange", func(ctx context.Context) (err error) {
+		entries, err = client.LRange(ctx, waitList, 0, markerScanLimit-1).Result()
+		return err
+	})
+	if err != nil {
+		return 0, s.keyReadError("waitList", waitList, "list", err)
+	}
+
+	var markers int64
+	for _, entry := range entries {
+		if strings.HasPrefix(entry, "0:") {
+			markers++
+		}
+	}
+	return markers, nil
+}
+
+// pausedResult interprets the pipelined HEXISTS of the paused flag. BullMQ sets a "paused"
+// field in the queue's meta hash; older Bull versions use a standalone key that only exists
+// while the queue is paused, which is detected with EXISTS when HEXISTS replies WRONGTYPE.
+func (s *server) pausedResult(ctx context.Context, client redisCmdable, pausedKey string, cmd *redis.BoolCmd) (bool, error) {
+	paused, err := cmd.Result()
+	if err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		var count int64
+		err = s.redisOp(ctx, "exists", func(ctx context.Context) (err error) {
+			count, err = client.Exists(ctx, pausedKey).Result()
+			return err
+		})
+		paused = count > 0
+	}
+	if err != nil {
+		return false, s.keyReadError("pausedKey", pausedKey, "hash or string", err)
+	}
+	return paused, nil
+}
+
+// keyReadError wraps a failed read of a configured key. Timeouts become DeadlineExceeded
+// gRPC errors naming the key, and WRONGTYPE errors get an explicit hint, since they mean
+// the metadata points at a key of the wrong Redis type.
+func (s *server) keyReadError(field, key, expected string, err error) error {
+	if isTimeoutError(err) {
+		return errorWithInfo(codes.DeadlineExceeded,
+			fmt.Sprintf("timed out after %s reading %s '%s': %v", s.redisOpTimeout, field, key, err),
+			reasonRedisTimeout, map[string]string{"field": field, "key": key})
+	}
+	if strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		return errorWithInfo(codes.InvalidArgument,
+			fmt.Sprintf("%s '%s' is not a %s; check that the %s metadata points at the right BullMQ key: %v", field, key, expected, field, err),
+			reasonKeyWrongType, map[string]string{"field": field, "key": key, "expected": expected})
+	}
+	return fmt.Errorf("failed to read %s '%s': %w", field, key, err)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+)
+
+// queueState holds the in-memory state tracked for a single queue across polls
+type queueState struct {
+	active   bool
+	lastSeen time.Time
+
+	// samples is a ring buffer of recent backlog readings used by percentile metrics
+	samples []int64
+	next    int
+	filled  bool
+
+	// ema is the exponential moving average used by smoothingFactor
+	ema    float64
+	hasEMA bool
+
+	// peaks holds the metric values reported within the smoothing window, oldest first
+	peaks []timedSample
+
+	// finished holds the completed+failed counts read within the completion window, oldest
+	// first
+	finished []timedSample
+
+	// lastMetrics and lastActive are the last good GetMetrics and IsActive answers, kept
+	// for staleValueTTL
+	lastMetrics   []*pb.MetricValue
+	lastMetricsAt time.Time
+	lastActive    bool
+	lastActiveAt  time.Time
+
+	// keysChecked is set once the key types have been checked
+	keysChecked bool
+}
+
+// queueStateStore is a concurrency-safe map of per-queue state keyed by queue identifier.
+// Queues that have not been polled for idleTTL are evicted to bound memory.
+type queueStateStore struct {
+	mu        sync.Mutex
+	states    map[string]*queueState
+	idleTTL   time.Duration
+	lastSweep time.Time
+}
+
+// newQueueStateStore creates an empty per-queue state store
+func newQueueStateStore(idleTTL time.Duration) *queueStateStore {
+	return &queueStateStore{
+		states:    make(map[string]*queueState),
+		idleTTL:   idleTTL,
+		lastSweep: time.Now(),
+	}
+}
+
+// get returns the state for a queue, creating it on first use. Callers must hold s.mu.
+func (s *queueStateStore) get(queue string) *queueState {
+	now := time.Now()
+	s.evictIdle(now)
+
+	state, exists := s.states[queue]
+	if !exists {
+		state = &queueState{}
+		s.states[queue] = state
+	}
+	state.lastSeen = now
+	return state
+}
+
+// evictIdle drops queues that have not been polled recently. The sweep runs at most once
+// per idleTTL so it stays cheap on the request path. Callers must hold s.mu.
+func (s *queueStateStore) evictIdle(now time.Time) {
+	if s.idleTTL <= 0 || now.Sub(s.lastSweep) < s.idleTTL {
+		return
+	}
+	s.lastSweep = now
+
+	for queue, state := range s.states {
+		if now.Sub(state.lastSeen) > s.idleTTL {
+			delete(s.states, queue)
+			slog.Debug("Evicted idle queue state", "queue", queue)
+		}
+	}
+}
+
+// recordActivity logs a single line when a queue transitions between idle and active.
+// Queues start out idle, so a queue that is empty on the first poll produces no log line.
+func (s *queueStateStore) recordActivity(queue string, total int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.get(queue)
+	active := total > 0
+	if active == state.active {
+		return
+	}
+	state.active = active
+
+	if active {
+		slog.Info("Queue became active", "queue", queue, "total", total)
+	} else {
+		slog.Info("Queue became idle", "queue", queue)
+	}
+}
+
+// markKeysChecked records that the key types of a queue are being checked and reports
+// whether this call was the first, so concurrent polls run the check only once. A check
+// that could not read Redis is released with unmarkKeysChecked, to run again.
+func (s *queueStateStore) markKeysChecked(queue string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.get(queue)
+	if state.keysChecked {
+		return false
+	}
+	state.keysChecked = true
+	return true
+}
+
+// unmarkKeysChecked releases the mark of a key type check that failed to read Redis, so
+// the next poll runs it again
+func (s *queueStateStore) unmarkKeysChecked(queue string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.get(queue).keysChecked = false
+}
+
+// addSample appends a reading to the queue's ring buffer of at most size samples and
+// returns a copy of the samples currently held. Changing size resets the buffer.
+func (s *queueStateStore) addSample(queue string, value int64, size int) []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.get(queue)
+	if len(state.samples) != size {
+		state.samples = make([]int64, size)
+		state.next = 0
+		state.filled = false
+	}
+
+	state.samples[state.next] = value
+	state.next = (state.next + 1) % size
+	if state.next == 0 {
+		state.filled = true
+	}
+
+	count := state.next
+	if state.filled {
+		count = size
+	}
+	samples := make([]int64, count)
+	copy(samples, state.samples[:count])
+	return samples
+}
+
+// smooth folds value into the exponential moving average kept under key and returns it.
+// factor is the weight given to history: 0 returns value unchanged, values close to 1
+// smooth heavily. The first sample seeds the average.
+func (s *queueStateStore) smooth(key string, value int64, factor float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.get(key)
+	if !state.hasEMA {
+		state.ema = float64(value)
+		state.hasEMA = true
+		return state.ema
+	}
+	state.ema = factor*state.ema + (1-factor)*float64(value)
+	return state.ema
+}
+
+// windowMax records value under key and returns the largest value recorded within window,
+// so a burst keeps the metric up for the whole window instead of one poll
+func (s *queueStateStore) windowMax(key string, value int64, now time.Time, window time.Duration) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.get(key)
+	start := now.Add(-window)
+	drop := 0
+	for drop < len(state.peaks) && !state.peaks[drop].at.After(start) {
+		drop++
+	}
+	if len(state.peaks)-drop >= maxSampleBufferSize {
+		drop = len(state.peaks) - maxSampleBufferSize + 1
+	}
+	state.peaks = append(state.peaks[drop:], timedSample{value: value, at: now})
+
+	largest := value
+	for _, peak := range state.peaks {
+		largest = max(largest, peak.value)
+	}
+	return largest
+}
+
+// timedSample is a reading and when it was taken
+type timedSample struct {
+	value int64
+	at    time.Time
+}
+
+// maxFinishedSamples bounds the counts kept per queue when polls are frequent
+const maxFinishedSamples = 1000
+
+// completionRate records the number of finished jobs under key and returns how many
+// finished per minute over the window, measured from the newest count read at or before
+// its start. A window of 0 measures since the previous call. ok is false on the first call,
+// and after the count drops (for example when BullMQ trims old jobs), since no delta is
+// available then.
+func (s *queueStateStore) completionRate(key string, finished int64, now time.Time, window time.Duration) (rate float64, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.get(key)
+	samples := state.finished
+	if len(samples) > 0 && finished < samples[len(samples)-1].value {
+		samples = nil
+	}
+	// Drop the counts a newer one at or before the window start makes redundant
+	start := now.Add(-window)
+	drop := 0
+	for drop+1 < len(samples) && !samples[drop+1].at.After(start) {
+		drop++
+	}
+	if len(samples)-drop >= maxFinishedSamples {
+		drop = len(samples) - maxFinishedSamples + 1
+	}
+	samples = samples[drop:]
+	state.finished = append(samples, timedSample{value: finished, at: now})
+
+	if len(samples) == 0 {
+		return 0, false
+	}
+	base := samples[0]
+	elapsed := now.Sub(base.at)
+	if elapsed <= 0 {
+		return 0, false
+	}
+	return float64(finished-base.value) / elapsed.Minutes(), true
+}
+
+// percentile returns the nearest-rank percentile p (0-100] of the samples
+func percentile(samples []int64, p float64) int64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := make([]int64, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+)
+
+// defaultRateLimitWindow matches KEDA's default pollingInterval
+const defaultRateLimitWindow = 30 * time.Second
+
+// rateLimit is a queue's rate limit: at most max jobs per duration
+type rateLimit struct {
+	max      int64
+	duration time.Duration
+}
+
+// rateLimitConfig is the respectRateLimit metadata. A limit given in the metadata wins;
+// otherwise it is read from the queue's meta hash, where BullMQ stores the global rate
+// limit set with Queue.setGlobalRateLimit.
+type rateLimitConfig struct {
+	limit   rateLimit // zero when read from metaKey
+	metaKey string
+	window  time.Duration
+}
+
+// rateLimitCap returns how many jobs the limit lets through in window, the most work that
+// pods started now could take on before the next poll. More pods would sit rate limited.
+func rateLimitCap(limit rateLimit, window time.Duration) int64 {
+	return int64(math.Ceil(float64(limit.max) * window.Seconds() / limit.duration.Seconds()))
+}
+
+// readRateLimit returns the rate limit of the queue: the configured one, or the one in its
+// meta hash. ok is false when the queue has no rate limit.
+func (s *server) readRateLimit(ctx context.Context, client redisCmdable, cfg rateLimitConfig) (limit rateLimit, ok bool, err error) {
+	if cfg.limit.max > 0 {
+		return cfg.limit, true, nil
+	}
+
+	var values []interface{}
+	err = s.redisOp(ctx, "hmget", func(ctx context.Context) (err error) {
+		values, err = client.HMGet(ctx, cfg.metaKey, "max", "duration").Result()
+		return err
+	})
+	if err != nil {
+		return rateLimit{}, false, s.keyReadError("rateLimitKey", cfg.metaKey, "hash", err)
+	}
+	field := func(value interface{}) int64 {
+		str, _ := value.(string)
+		parsed, _ := strconv.ParseInt(str, 10, 64)
+		return parsed
+	}
+	limit = rateLimit{max: field(values[0]), duration: time.Duration(field(values[1])) * time.Millisecond}
+	return limit, limit.max > 0 && limit.duration > 0, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redacted replaces secrets in log lines and error messages
+const redacted = "[REDACTED]"
+
+// sensitiveAttrPattern matches log attribute keys whose values are secrets. "key" itself
+// names Redis keys in log lines, so it is not included.
+var sensitiveAttrPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential)`)
+
+// sensitiveKeyPattern matches trigger metadata keys whose values are secrets, including
+// the TriggerAuthentication key parameter holding a private key
+var sensitiveKeyPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|^key$)`)
+
+// minSecretLength is the shortest secret value replaced inside other text, so a trivial
+// value does not blank out unrelated words
+const minSecretLength = 4
+
+// maxKnownSecrets bounds the secret values remembered, which grow as passwords rotate
+const maxKnownSecrets = 256
+
+// knownSecrets holds secret values seen so far, masked wherever they appear in a log line
+// or error message, such as a password echoed back in a Redis or parse error
+var knownSecrets = struct {
+	sync.RWMutex
+	values map[string]bool
+}{values: make(map[string]bool)}
+
+// registerSecret remembers a secret value so it is redacted from then on
+func registerSecret(value string) {
+	if len(value) < minSecretLength {
+		return
+	}
+	knownSecrets.Lock()
+	defer knownSecrets.Unlock()
+	if len(knownSecrets.values) < maxKnownSecrets {
+		knownSecrets.values[value] = true
+	}
+}
+
+// registerSensitiveMetadata remembers the values of sensitive trigger metadata keys
+func registerSensitiveMetadata(metadata map[string]string) {
+	for key, value := range metadata {
+		if sensitiveKeyPattern.MatchString(key) {
+			registerSecret(value)
+		}
+	}
+}
+
+// redactSecrets masks every known secret value in s
+func redactSecrets(s string) string {
+	knownSecrets.RLock()
+	defer knownSecrets.RUnlock()
+	for secret := range knownSecrets.values {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+// redactAttr is the slog ReplaceAttr hook: it masks attributes with a sensitive key and
+// known secret values inside strings and errors, including the message itself
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveAttrPattern.MatchString(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactSecrets(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, redactSecrets(err.Error()))
+		}
+	}
+	return a
+}
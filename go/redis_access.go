@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// accessCheckKeySuffix replaces the wildcard of a REDIS_ACCESS_CHECK_KEYS pattern to name
+// a key that matches it, and that no queue uses
+const accessCheckKeySuffix = "__scaler_access_check__"
+
+// redisAccessState holds the result of the last Redis permission check
+type redisAccessState struct {
+	mu      sync.Mutex
+	checked bool
+	lastErr error
+}
+
+// set records a check result, logging only when it changes
+func (a *redisAccessState) set(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case err != nil && (a.lastErr == nil || a.lastErr.Error() != err.Error()):
+		slog.Error("Redis permission check failed; the scaler reports not ready until it passes", "error", err)
+	case err == nil && (a.lastErr != nil || !a.checked):
+		slog.Info("Redis permission check passed")
+	}
+	a.checked = true
+	a.lastErr = err
+}
+
+// err returns the failure of the last check, nil when it passed or has not run
+func (a *redisAccessState) err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastErr
+}
+
+// checkRedisAccess verifies that the Redis user of the default client may run the read
+// commands the scaler issues on keys matching each REDIS_ACCESS_CHECK_KEYS pattern. Redis
+// 6 ACLs reject commands with NOPERM only when they are run, so a user without key or
+// command permissions would otherwise surface as failing polls. Each pattern is probed
+// with its wildcard replaced, on a key that does not exist, so nothing is read. Errors
+// other than NOPERM replies, such as a lost connection, are returned without recording a
+// result.
+func (s *server) checkRedisAccess(ctx context.Context) error {
+	if len(s.accessCheckKeys) == 0 {
+		return nil
+	}
+	// Redis before 6 has no ACL command, and then no ACLs to check either
+	user := "default"
+	err := s.redisOp(ctx, "acl_whoami", func(ctx context.Context) (err error) {
+		user, err = s.redisClient.Do(ctx, "ACL", "WHOAMI").Text()
+		return err
+	})
+	if err != nil && !isRedisReplyError(err) {
+		return err
+	}
+
+	var problems []string
+	for _, pattern := range s.accessCheckKeys {
+		key := strings.ReplaceAll(pattern, "*", accessCheckKeySuffix)
+		var cmds []redis.Cmder
+		err := s.redisOp(ctx, "access_check", func(ctx context.Context) (err error) {
+			pipe := s.redisClient.Pipeline()
+			pipe.LLen(ctx, key)
+			pipe.ZCard(ctx, key)
+			pipe.SCard(ctx, key)
+			pipe.HExists(ctx, key, "paused")
+			cmds, err = pipe.Exec(ctx)
+			return err
+		})
+		if err != nil && !isRedisReplyError(err) {
+			return err
+		}
+		var denied []string
+		for _, cmd := range cmds {
+			if cmdErr := cmd.Err(); cmdErr != nil && strings.HasPrefix(cmdErr.Error(), "NOPERM") {
+				denied = append(denied, strings.ToUpper(cmd.Name()))
+			}
+		}
+		if len(denied) > 0 {
+			problems = append(problems, fmt.Sprintf("%s on keys matching %s", strings.Join(denied, ", "), pattern))
+		}
+	}
+
+	err = nil
+	if len(problems) > 0 {
+		err = fmt.Errorf("redis user %q is not allowed to run %s; grant read access, e.g. ACL SETUSER %s ~%s +@read",
+			user, strings.Join(problems, "; "), user, s.accessCheckKeys[0])
+	}
+	s.redisAccess.set(err)
+	return err
+}
+
+// startupAccessCheck runs the permission check once the default client has connected, so
+// a missing permission is logged at startup rather than at the first poll
+func (s *server) startupAccessCheck() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*s.redisOpTimeout)
+	defer cancel()
+	if err := s.checkRedisAccess(ctx); err != nil && s.redisAccess.err() == nil {
+		slog.Warn("Could not check Redis permissions", "error", err)
+	}
+}
+
+// accessCheckPatterns returns the key patterns to check from REDIS_ACCESS_CHECK_KEYS,
+// by default every key under the default queuePrefix. REDIS_ACCESS_CHECK=false disables
+// the check.
+func accessCheckPatterns() []string {
+	if !getEnvBool("REDIS_ACCESS_CHECK", true) {
+		return nil
+	}
+	prefix := defaultQueuePrefix
+	if defaults := metadataDefaults.Load(); defaults != nil && (*defaults)["queuePrefix"] != "" {
+		prefix = (*defaults)["queuePrefix"]
+	}
+	return parseKeyList(getEnvDefault("REDIS_ACCESS_CHECK_KEYS", prefix+":*"))
+}
+
+// ready reports whether the scaler can serve: Redis answers a Ping, and the permission
+// check passes. A failed check is re-run, so fixing the ACL makes the pod ready again.
+func (s *server) ready(ctx context.Context) error {
+	if err := s.redisClient.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("redis unreachable: %w", err)
+	}
+	if s.redisAccess.err() != nil {
+		return s.checkRedisAccess(ctx)
+	}
+	return nil
+}
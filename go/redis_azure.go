@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// azureRedisResource is the Entra ID resource of Azure Cache for Redis
+	azureRedisResource = "https://redis.azure.com"
+	// azureMaxConnAge recycles connections well within a token's lifetime, since Azure
+	// Cache for Redis closes connections whose token has expired
+	azureMaxConnAge = 30 * time.Minute
+	// azureTokenMinValidity is how long a token must stay valid to be handed to a new
+	// connection, so no connection outlives its token
+	azureTokenMinValidity = azureMaxConnAge + 5*time.Minute
+	// azureTokenTimeout bounds a token request
+	azureTokenTimeout = 10 * time.Second
+	// azureIMDSEndpoint is the managed identity endpoint of Azure VMs and AKS nodes
+	azureIMDSEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+// configureEntraAuth authenticates every new Redis connection with an Entra ID token as
+// the password, for Azure Cache for Redis without stored credentials. As with IAM auth the
+// token is sent from the OnConnect hook, and connections are recycled every
+// azureMaxConnAge so they re-authenticate with a current token. REDIS_USERNAME is the
+// object ID of the identity, as Azure requires.
+func configureEntraAuth(cfg *redisConnConfig, serverName string) {
+	username := cfg.username
+	if username == "" {
+		fatal("Missing required env var: REDIS_USERNAME (the object ID of the identity for Entra ID authentication)")
+	}
+	provider := newAzureTokenProvider()
+
+	cfg.password = ""
+	cfg.maxConnAge = azureMaxConnAge
+	cfg.onConnect = func(ctx context.Context, cn *redis.Conn) error {
+		token, err := provider.Token(ctx)
+		if err != nil {
+			return err
+		}
+		return cn.AuthACL(ctx, username, token).Err()
+	}
+
+	// Azure Cache for Redis only accepts Entra ID authentication over TLS
+	if cfg.tlsConfig == nil {
+		cfg.tlsConfig = &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	}
+
+	slog.Info("Redis Entra ID authentication enabled", "username", username, "source", provider.source)
+}
+
+// azureTokenProvider fetches Entra ID tokens for Azure Cache for Redis and caches each
+// until it is too close to expiry for a new connection. With AKS workload identity
+// (AZURE_FEDERATED_TOKEN_FILE set) the pod's federated token is exchanged for one;
+// otherwise the managed identity endpoint is asked, for AZURE_CLIENT_ID if set.
+type azureTokenProvider struct {
+	source string
+	fetch  func(ctx context.Context) (azureToken, error)
+	http   *http.Client
+
+	mu    sync.Mutex
+	token azureToken
+}
+
+// azureToken is an Entra ID access token and when it expires
+type azureToken struct {
+	value   string
+	expires time.Time
+}
+
+// newAzureTokenProvider picks workload identity or managed identity from the environment
+func newAzureTokenProvider() *azureTokenProvider {
+	p := &azureTokenProvider{http: &http.Client{Timeout: azureTokenTimeout}}
+	if os.Getenv("AZURE_FEDERATED_TOKEN_FILE") != "" {
+		p.source = "workload identity"
+		p.fetch = p.fetchWorkloadIdentity
+	} else {
+		p.source = "managed identity"
+		p.fetch = p.fetchManagedIdentity
+	}
+	return p
+}
+
+// Token returns the cached token, fetching a new one when it expires within
+// azureTokenMinValidity
+func (p *azureTokenProvider) Token(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.token.value != "" && time.Until(p.token.expires) > azureTokenMinValidity {
+		return p.token.value, nil
+	}
+	token, err := p.fetch(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Entra ID token from %s: %w", p.source, err)
+	}
+	p.token = token
+	slog.Debug("Fetched Entra ID token for Redis", "source", p.source, "expires", token.expires)
+	return token.value, nil
+}
+
+// fetchWorkloadIdentity exchanges the federated service account token for an access token
+func (p *azureTokenProvider) fetchWorkloadIdentity(ctx context.Context) (azureToken, error) {
+	tenantID, clientID := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID")
+	if tenantID == "" || clientID == "" {
+		return azureToken{}, errors.New("AZURE_TENANT_ID and AZURE_CLIENT_ID must be set with AZURE_FEDERATED_TOKEN_FILE")
+	}
+	// The file is re-read every time, since the kubelet rotates it
+	assertion, err := os.ReadFile(os.Getenv("AZURE_FEDERATED_TOKEN_FILE"))
+	if err != nil {
+		return azureToken{}, err
+	}
+	authority := getEnvDefault("AZURE_AUTHORITY_HOST", "https://login.microsoftonline.com/")
+	form := url.Values{
+		"client_id":             {clientID},
+		"scope":                 {azureRedisResource + "/.default"},
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {strings.TrimSpace(string(assertion))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(authority, "/")+"/"+url.PathEscape(tenantID)+"/oauth2/v2.0/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return azureToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return p.do(req)
+}
+
+// fetchManagedIdentity asks the instance metadata service for a managed identity token
+func (p *azureTokenProvider) fetchManagedIdentity(ctx context.Context) (azureToken, error) {
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {azureRedisResource}}
+	if clientID := os.Getenv("AZURE_CLIENT_ID"); clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, azureIMDSEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return azureToken{}, err
+	}
+	req.Header.Set("Metadata", "true")
+	return p.do(req)
+}
+
+// do sends a token request and parses the response. expires_in is a number from Entra ID
+// and a string from the metadata service, so both are accepted.
+func (p *azureTokenProvider) do(req *http.Request) (azureToken, error) {
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return azureToken{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return azureToken{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return azureToken{}, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var parsed struct {
+		AccessToken string          `json:"access_token"`
+		ExpiresIn   json.RawMessage `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return azureToken{}, fmt.Errorf("invalid token response: %w", err)
+	}
+	seconds, err := strconv.Atoi(strings.Trim(string(parsed.ExpiresIn), `"`))
+	if err != nil || parsed.AccessToken == "" {
+		return azureToken{}, errors.New("token response is missing access_token or expires_in")
+	}
+	return azureToken{value: parsed.AccessToken, expires: time.Now().Add(time.Duration(seconds) * time.Second)}, nil
+}
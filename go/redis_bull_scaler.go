@@ -2,32 +2,148 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	pb "github.com/avishay/redis-bull-scaler/externalscaler"
-	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+const (
+	// defaultSampleBufferSize is the number of recent samples kept for percentile metrics
+	defaultSampleBufferSize = 30
+	// maxSampleBufferSize bounds the per-queue sample buffer
+	maxSampleBufferSize = 1000
+
+	// queueLengthMetric, rawLengthMetric, delayedMetric, completionMetric, stalledMetric,
+	// failureMetric and ageMetric are the base names of the metrics returned to KEDA
+	queueLengthMetric = "bull_queue_length"
+	rawLengthMetric   = "bull_queue_length_raw"
+	delayedMetric     = "bull_queue_delayed"
+	completionMetric  = "bull_completion_rate"
+	stalledMetric     = "bull_queue_stalled"
+	failureMetric     = "bull_failure_rate"
+	ageMetric         = "bull_oldest_job_age_seconds"
 )
 
 // server implements the KEDA ExternalScaler gRPC interface
 type server struct {
 	pb.UnimplementedExternalScalerServer
-	redisClient *redis.Client
+	redisClient redisCmdable
+	queueStates *queueStateStore
+
+	// redisPool holds clients for ScaledObjects that override the Redis connection
+	redisPool *redisClientPool
+
+	// redisOpTimeout bounds every individual Redis command
+	redisOpTimeout time.Duration
+
+	// redisOps bounds the Redis commands in flight, nil when MAX_CONCURRENT_REDIS_OPS is 0
+	redisOps *redisOpLimiter
+
+	// requestTimeout bounds all Redis reads of one IsActive or GetMetrics call together
+	requestTimeout time.Duration
+
+	// accessCheckKeys are the key patterns the Redis user must be able to read; nil skips
+	// the permission check
+	accessCheckKeys []string
+	redisAccess     *redisAccessState
+
+	// breakers fail queue reads fast while a Redis endpoint keeps failing; nil when disabled
+	breakers *circuitBreakers
+
+	// lengthCache reuses recent queue length reads across IsActive and GetMetrics calls
+	lengthCache *lengthCache
+
+	// tracking reuses queue length reads until Redis reports a change, nil when
+	// REDIS_CLIENT_TRACKING is off
+	tracking *trackingCache
+
+	// readGroup deduplicates concurrent identical queue reads when singleflight is enabled
+	readGroup   *singleflight.Group
+	dedupeReads bool
+
+	// background bounds goroutines spawned by streaming and background features
+	background *goroutineLimiter
+
+	// streamPollInterval is how often StreamIsActive re-checks queue lengths
+	streamPollInterval time.Duration
+
+	// keyspaceNotifications wakes streams on Redis keyspace events between polls
+	keyspaceNotifications bool
+
+	// streamLeases lets one replica per trigger check and push on streams, nil when
+	// STREAM_LEASE_ENABLED is off
+	streamLeases *streamLeases
+
+	// scanCount and scanMaxKeys bound the SCAN behind waitListPattern
+	scanCount   int64
+	scanMaxKeys int
+
+	// scanCache reuses the keys a waitListPattern matched across polls
+	scanCache *scanCache
+
+	// redisConn reports whether the default client has connected since startup
+	redisConn *redisConnState
+
+	// triggers records the ScaledObjects served recently, for /debug/state
+	triggers *triggerRegistry
 }
 
 // getEnv fetches a required environment variable and fails fast if missing
 func getEnv(key string) string {
 	val := os.Getenv(key)
 	if val == "" {
-		log.Fatalf("Missing required env var: %s", key)
+		fatal("Missing required env var", "key", key)
+	}
+	return val
+}
+
+// getEnvDefault fetches an optional environment variable, returning def when unset
+func getEnvDefault(key, def string) string {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
 	}
 	return val
 }
 
+// getEnvBool fetches an optional boolean environment variable and fails fast if it is not a valid bool
+func getEnvBool(key string, def bool) bool {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
+	}
+	parsed, err := strconv.ParseBool(val)
+	if err != nil {
+		fatal("Invalid env var: must be a boolean", "key", key, "value", val)
+	}
+	return parsed
+}
+
+// getEnvDuration fetches an optional duration environment variable and fails fast if it is invalid
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
+	}
+	parsed, err := time.ParseDuration(val)
+	if err != nil || parsed < 0 {
+		fatal("Invalid env var: must be a non-negative duration (e.g. 30s, 5m)", "key", key, "value", val)
+	}
+	return parsed
+}
+
 // getMetadataValue extracts and validates metadata from ScaledObjectRef
 func getMetadataValue(metadata map[string]string, key string) (string, error) {
 	value, exists := metadata[key]
@@ -37,6 +153,213 @@ func getMetadataValue(metadata map[string]string, key string) (string, error) {
 	return value, nil
 }
 
+// requiredMetadataKeys lists the trigger metadata keys every ScaledObject must provide.
+// The queue can be given either by name or by its explicit wait and active list keys.
+var requiredMetadataKeys = []string{"queueName (or waitListPattern, or waitList and activeList)"}
+
+// checkMetadataPresent rejects a ScaledObject that carries no trigger metadata at all with a
+// single InvalidArgument error that lists every required key, instead of failing on the first one
+func checkMetadataPresent(metadata map[string]string) error {
+	if len(metadata) > 0 {
+		return nil
+	}
+	problems := make([]string, 0, len(requiredMetadataKeys))
+	for _, key := range requiredMetadataKeys {
+		problems = append(problems, "required metadata "+key+" is missing")
+	}
+	return invalidMetadataError(fmt.Sprintf(
+		"trigger metadata is empty; required keys: %s. Example trigger metadata: "+
+			"queueName: my-queue, targetSize: \"10\" "+
+			"(queueName, waitList and activeList accept comma-separated values to aggregate several queues)",
+		strings.Join(requiredMetadataKeys, ", ")), problems)
+}
+
+// getMetadataDefault returns an optional metadata value, or def when it is absent or empty
+func getMetadataDefault(metadata map[string]string, key, def string) string {
+	if value := metadata[key]; value != "" {
+		return value
+	}
+	return def
+}
+
+// metadataAliases lists the alternative names accepted for a metadata key, for names that
+// read better in some setups or match other scalers' conventions
+var metadataAliases = map[string][]string{
+	"targetSize":          {"targetQueueLength", "jobsPerPod"},
+	"activationThreshold": {"activationListLength"},
+	"queueName":           {"queues"},
+	"queuePrefix":         {"keyPrefix"},
+	"waitListPattern":     {"queuePattern"},
+}
+
+// metadataKey returns which of key and its aliases the metadata sets, or key when none is
+// set, so callers parse the value under the name the user wrote. Setting several of them
+// to different values is an error.
+func metadataKey(metadata map[string]string, key string) (string, error) {
+	found := key
+	for _, name := range append([]string{key}, metadataAliases[key]...) {
+		value := metadata[name]
+		if value == "" {
+			continue
+		}
+		if metadata[found] == "" {
+			found = name
+		} else if metadata[found] != value {
+			return "", fmt.Errorf("%s and %s are aliases and disagree: %s, %s", found, name, metadata[found], value)
+		}
+	}
+	return found, nil
+}
+
+// getMetadataBool parses an optional boolean metadata value, returning def when absent
+func getMetadataBool(metadata map[string]string, key string, def bool) (bool, error) {
+	value, exists := metadata[key]
+	if !exists || value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be a boolean, got: %s", key, value)
+	}
+	return parsed, nil
+}
+
+// getMetadataPositiveInt parses an optional positive integer metadata value, returning def when absent
+func getMetadataPositiveInt(metadata map[string]string, key string, def int64) (int64, error) {
+	value, exists := metadata[key]
+	if !exists || value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got: %s", key, value)
+	}
+	return parsed, nil
+}
+
+// getMetadataNonNegativeInt parses an optional non-negative integer metadata value, returning def when absent
+func getMetadataNonNegativeInt(metadata map[string]string, key string, def int64) (int64, error) {
+	value, exists := metadata[key]
+	if !exists || value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer, got: %s", key, value)
+	}
+	return parsed, nil
+}
+
+// getMetadataNonNegativeFloat parses an optional non-negative float metadata value, returning def when absent
+func getMetadataNonNegativeFloat(metadata map[string]string, key string, def float64) (float64, error) {
+	value, exists := metadata[key]
+	if !exists || value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 || math.IsInf(parsed, 0) || math.IsNaN(parsed) {
+		return 0, fmt.Errorf("%s must be a non-negative number, got: %s", key, value)
+	}
+	return parsed, nil
+}
+
+// getMetadataPositiveFloat parses an optional positive float metadata value, returning def when absent
+func getMetadataPositiveFloat(metadata map[string]string, key string, def float64) (float64, error) {
+	value, exists := metadata[key]
+	if !exists || value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed <= 0 || math.IsInf(parsed, 0) || math.IsNaN(parsed) {
+		return 0, fmt.Errorf("%s must be a positive number, got: %s", key, value)
+	}
+	return parsed, nil
+}
+
+// getMetadataDuration parses an optional non-negative duration metadata value, returning def when absent
+func getMetadataDuration(metadata map[string]string, key string, def time.Duration) (time.Duration, error) {
+	value, exists := metadata[key]
+	if !exists || value == "" {
+		return def, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative duration (e.g. 30s, 5m), got: %s", key, value)
+	}
+	return parsed, nil
+}
+
+// parseMetricType parses the metricType metadata value. It returns 0 for the default
+// instantaneous mode, or the requested percentile for values like "p95".
+func parseMetricType(value string) (float64, error) {
+	if value == "" || value == "instantaneous" {
+		return 0, nil
+	}
+	if strings.HasPrefix(value, "p") {
+		p, err := strconv.ParseFloat(strings.TrimPrefix(value, "p"), 64)
+		if err == nil && p > 0 && p <= 100 {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("metricType must be \"instantaneous\" or a percentile between p1 and p100, got: %s", value)
+}
+
+// Values of the scaleOn metadata
+const (
+	scaleOnLength        = "length"
+	scaleOnOldestJobAge  = "oldestJobAge"
+	scaleOnEventsBacklog = "eventsBacklog"
+)
+
+// parseScaleOn parses the scaleOn metadata value: what GetMetrics returns instead of the
+// queue length, the oldest job's age in seconds or the events stream backlog
+func parseScaleOn(value string) (string, error) {
+	switch value {
+	case "", scaleOnLength:
+		return scaleOnLength, nil
+	case scaleOnOldestJobAge, scaleOnEventsBacklog:
+		return value, nil
+	}
+	return "", fmt.Errorf("scaleOn must be \"length\", \"oldestJobAge\" or \"eventsBacklog\", got: %s", value)
+}
+
+// parseMetricName validates the metricName metadata value. KEDA uses the name in the
+// external metric it registers, so it is limited to letters, digits, '_' and '-', starting
+// with a letter. An empty value keeps the default name.
+func parseMetricName(value string) (string, error) {
+	for i, r := range value {
+		letter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if i == 0 && !letter {
+			return "", fmt.Errorf("metricName must start with a letter, got: %s", value)
+		}
+		if !letter && !(r >= '0' && r <= '9') && r != '_' && r != '-' {
+			return "", fmt.Errorf("metricName may only contain letters, digits, '_' and '-', got: %s", value)
+		}
+	}
+	return value, nil
+}
+
+// scopedMetricName appends the sanitized ScaledObject name to a base metric name, e.g.
+// "bull_queue_length_email_worker", so ScaledObjects served by one scaler do not collide
+// in KEDA's metrics server. The bare base name is used when the ref carries no name.
+func scopedMetricName(base string, ref *pb.ScaledObjectRef) string {
+	if ref == nil || ref.Name == "" {
+		return base
+	}
+	return base + "_" + sanitizeMetricPart(ref.Name)
+}
+
+// sanitizeMetricPart replaces characters outside [A-Za-z0-9_] with '_' so a name can be
+// part of a metric name
+func sanitizeMetricPart(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
 // validatePortNumber validates that a string represents a valid port number
 func validatePortNumber(portStr string) error {
 	port, err := strconv.Atoi(portStr)
@@ -49,148 +372,757 @@ func validatePortNumber(portStr string) error {
 	return nil
 }
 
-// NewServer initializes the scaler server with Redis connection
-func NewServer() *server {
-	redisHost := getEnv("REDIS_HOST")
-	redisPort := getEnv("REDIS_PORT")
+// serverConfig holds the tunables read from the environment by NewServer
+type serverConfig struct {
+	redisOpTimeout     time.Duration
+	requestTimeout     time.Duration
+	breakerThreshold   int
+	breakerCooldown    time.Duration
+	accessCheckKeys    []string
+	singleflight       bool
+	queueStateIdleTTL  time.Duration
+	metricCacheTTL     time.Duration
+	maxBackground      int
+	maxRedisOps        int
+	streamPollInterval time.Duration
+	keyspaceEvents     bool
+	redisPoolIdleTTL   time.Duration
+	redisTLS           *tls.Config // for the default client when REDIS_TLS_ENABLED, and redisTLS overrides
+	scanCount          int64
+	scanMaxKeys        int
+	scanCacheTTL       time.Duration
+}
 
-	// Validate port number
-	if err := validatePortNumber(redisPort); err != nil {
-		log.Fatalf("Invalid REDIS_PORT: %v", err)
+// defaultServerConfig returns the settings NewServer uses when no env vars are set
+func defaultServerConfig() serverConfig {
+	return serverConfig{
+		redisOpTimeout:     3 * time.Second,
+		requestTimeout:     10 * time.Second,
+		breakerThreshold:   5,
+		breakerCooldown:    30 * time.Second,
+		singleflight:       true,
+		queueStateIdleTTL:  10 * time.Minute,
+		metricCacheTTL:     time.Second,
+		maxBackground:      1000,
+		streamPollInterval: 5 * time.Second,
+		redisPoolIdleTTL:   10 * time.Minute,
+		scanCount:          100,
+		scanMaxKeys:        1000,
+		scanCacheTTL:       30 * time.Second,
 	}
+}
 
-	rdb := redis.NewClient(&redis.Options{
-		Addr: fmt.Sprintf("%s:%s", redisHost, redisPort),
-	})
+// NewServer initializes the scaler server from the environment, connecting to Redis
+func NewServer() *server {
+	def := defaultServerConfig()
+	cfg := serverConfig{
+		redisOpTimeout:     getEnvDuration("REDIS_OP_TIMEOUT", def.redisOpTimeout),
+		requestTimeout:     getEnvDuration("REQUEST_TIMEOUT", def.requestTimeout),
+		breakerCooldown:    getEnvDuration("CIRCUIT_BREAKER_COOLDOWN", def.breakerCooldown),
+		accessCheckKeys:    accessCheckPatterns(),
+		singleflight:       getEnvBool("SINGLEFLIGHT_ENABLED", def.singleflight),
+		queueStateIdleTTL:  getEnvDuration("QUEUE_STATE_IDLE_TTL", def.queueStateIdleTTL),
+		metricCacheTTL:     getEnvDuration("METRIC_CACHE_TTL", def.metricCacheTTL),
+		streamPollInterval: getEnvDuration("STREAM_POLL_INTERVAL", def.streamPollInterval),
+		keyspaceEvents:     getEnvBool("STREAM_KEYSPACE_NOTIFICATIONS", def.keyspaceEvents),
+		redisPoolIdleTTL:   getEnvDuration("REDIS_OVERRIDE_IDLE_TTL", def.redisPoolIdleTTL),
+		scanCacheTTL:       getEnvDuration("SCAN_CACHE_TTL", def.scanCacheTTL),
+	}
+	if cfg.redisOpTimeout <= 0 {
+		fatal("Invalid REDIS_OP_TIMEOUT: must be greater than zero")
+	}
+	if _, err := parseMetricName(os.Getenv("METRIC_NAME_PREFIX")); err != nil {
+		fatal("Invalid METRIC_NAME_PREFIX: must start with a letter and contain only letters, digits, '_' and '-'",
+			"value", os.Getenv("METRIC_NAME_PREFIX"))
+	}
+	metricNamePrefix = os.Getenv("METRIC_NAME_PREFIX")
+
+	var err error
+	if allowedKeyPatterns, err = parseAllowedKeyPatterns(os.Getenv("ALLOWED_KEY_PATTERNS")); err != nil {
+		fatal("Invalid ALLOWED_KEY_PATTERNS", "error", err)
+	}
+	if namespaceKeyPrefix, err = parseNamespaceKeyPrefix(os.Getenv("NAMESPACE_KEY_PREFIX")); err != nil {
+		fatal("Invalid NAMESPACE_KEY_PREFIX", "error", err)
+	}
+	allowedSecretNamespaces = parseKeyList(os.Getenv("ALLOWED_SECRET_NAMESPACES"))
+	cfg.maxBackground, err = strconv.Atoi(getEnvDefault("MAX_BACKGROUND_GOROUTINES", strconv.Itoa(def.maxBackground)))
+	if err != nil || cfg.maxBackground <= 0 {
+		fatal("Invalid MAX_BACKGROUND_GOROUTINES: must be a positive integer", "value", os.Getenv("MAX_BACKGROUND_GOROUTINES"))
+	}
+	cfg.maxRedisOps, err = strconv.Atoi(getEnvDefault("MAX_CONCURRENT_REDIS_OPS", strconv.Itoa(def.maxRedisOps)))
+	if err != nil || cfg.maxRedisOps < 0 {
+		fatal("Invalid MAX_CONCURRENT_REDIS_OPS: must be a non-negative integer (0 means unlimited)", "value", os.Getenv("MAX_CONCURRENT_REDIS_OPS"))
+	}
+	cfg.breakerThreshold, err = strconv.Atoi(getEnvDefault("CIRCUIT_BREAKER_THRESHOLD", strconv.Itoa(def.breakerThreshold)))
+	if err != nil || cfg.breakerThreshold < 0 {
+		fatal("Invalid CIRCUIT_BREAKER_THRESHOLD: must be a non-negative integer (0 disables the breaker)", "value", os.Getenv("CIRCUIT_BREAKER_THRESHOLD"))
+	}
+	if cfg.breakerCooldown <= 0 {
+		fatal("Invalid CIRCUIT_BREAKER_COOLDOWN: must be greater than zero")
+	}
+	if cfg.streamPollInterval <= 0 {
+		fatal("Invalid STREAM_POLL_INTERVAL: must be greater than zero")
+	}
+	cfg.scanCount, err = strconv.ParseInt(getEnvDefault("SCAN_COUNT", strconv.FormatInt(def.scanCount, 10)), 10, 64)
+	if err != nil || cfg.scanCount <= 0 {
+		fatal("Invalid SCAN_COUNT: must be a positive integer", "value", os.Getenv("SCAN_COUNT"))
+	}
+	cfg.scanMaxKeys, err = strconv.Atoi(getEnvDefault("SCAN_MAX_KEYS", strconv.Itoa(def.scanMaxKeys)))
+	if err != nil || cfg.scanMaxKeys <= 0 {
+		fatal("Invalid SCAN_MAX_KEYS: must be a positive integer", "value", os.Getenv("SCAN_MAX_KEYS"))
+	}
 
-	// Test Redis connection
-	if err := rdb.Ping(context.Background()).Err(); err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+	cfg.redisTLS = redisTLSConfig()
+	var defaultTLS *tls.Config
+	if getEnvBool("REDIS_TLS_ENABLED", false) {
+		defaultTLS = cfg.redisTLS
+	}
+	rdb, pingErr := connectRedis(cfg.redisOpTimeout, defaultTLS)
+	s := newServer(rdb, cfg)
+	if replicas := os.Getenv("REDIS_REPLICA_ADDRS"); replicas != "" {
+		s.redisPool.addReplicas(rdb, parseHostPortList("REDIS_REPLICA_ADDRS", replicas))
+	}
+	if getEnvBool("REDIS_CLIENT_TRACKING", false) {
+		s.tracking = startClientTracking(rdb, clientTrackingPrefixes(), getEnvDuration("REDIS_CLIENT_TRACKING_MAX_AGE", time.Minute))
+	}
+	s.streamLeases = newStreamLeases(cfg.streamPollInterval)
+	registerGoroutineMetrics(s.background)
+	registerRedisOpMetrics(s.redisOps)
+	if pingErr != nil {
+		s.redisConn.set(pingErr)
+		s.background.tryGo("redis reconnect", s.awaitRedis)
+	} else {
+		s.startupAccessCheck()
 	}
 
-	log.Printf("Connected to Redis at %s:%s", redisHost, redisPort)
-	log.Printf("External scaler ready - queue configuration will come from ScaledJob metadata")
+	slog.Info("External scaler ready - queue configuration will come from ScaledJob metadata",
+		"singleflight", cfg.singleflight,
+		"metricCacheTTL", cfg.metricCacheTTL,
+		"redisOpTimeout", cfg.redisOpTimeout,
+		"requestTimeout", cfg.requestTimeout,
+		"circuitBreakerThreshold", cfg.breakerThreshold,
+		"circuitBreakerCooldown", cfg.breakerCooldown,
+		"redisAccessCheckKeys", cfg.accessCheckKeys,
+		"maxBackgroundGoroutines", cfg.maxBackground,
+		"maxConcurrentRedisOps", cfg.maxRedisOps,
+		"streamPollInterval", cfg.streamPollInterval,
+		"streamKeyspaceNotifications", cfg.keyspaceEvents,
+		"streamLeases", s.streamLeases != nil,
+		"scanCount", cfg.scanCount,
+		"scanMaxKeys", cfg.scanMaxKeys,
+		"scanCacheTTL", cfg.scanCacheTTL)
+	return s
+}
 
+// newServer builds a server around an existing Redis client without touching the
+// environment or global metrics registration, so a fake or miniredis-backed client can
+// be injected
+func newServer(client redisCmdable, cfg serverConfig) *server {
 	return &server{
-		redisClient: rdb,
+		redisClient:           client,
+		queueStates:           newQueueStateStore(cfg.queueStateIdleTTL),
+		redisPool:             newRedisClientPool(cfg.redisPoolIdleTTL, cfg.redisTLS),
+		redisOpTimeout:        cfg.redisOpTimeout,
+		redisOps:              newRedisOpLimiter(cfg.maxRedisOps),
+		requestTimeout:        cfg.requestTimeout,
+		breakers:              newCircuitBreakers(cfg.breakerThreshold, cfg.breakerCooldown),
+		accessCheckKeys:       cfg.accessCheckKeys,
+		redisAccess:           &redisAccessState{},
+		lengthCache:           newLengthCache(cfg.metricCacheTTL),
+		readGroup:             &singleflight.Group{},
+		dedupeReads:           cfg.singleflight,
+		background:            newGoroutineLimiter(cfg.maxBackground),
+		streamPollInterval:    cfg.streamPollInterval,
+		keyspaceNotifications: cfg.keyspaceEvents,
+		scanCount:             cfg.scanCount,
+		scanMaxKeys:           cfg.scanMaxKeys,
+		scanCache:             newScanCache(cfg.scanCacheTTL),
+		triggers:              newTriggerRegistry(cfg.queueStateIdleTTL),
+		redisConn:             &redisConnState{connected: true},
 	}
 }
 
-// IsActive returns true if there is at least one item in either wait or active list
-func (s *server) IsActive(ctx context.Context, req *pb.ScaledObjectRef) (*pb.IsActiveResponse, error) {
-	log.Printf("[IsActive] Called for ScaledObject: %s/%s", req.Namespace, req.Name)
+// IsActive returns true if the number of jobs across the configured queue keys exceeds activationThreshold
+func (s *server) IsActive(ctx context.Context, req *pb.ScaledObjectRef) (resp *pb.IsActiveResponse, err error) {
+	defer func() {
+		observeRequest("IsActive", err)
+		s.triggers.recordActive(req, resp, err)
+	}()
+	logger := requestLogger(ctx, "IsActive", req)
+	logger.Debug("Called")
 
-	waitList, err := getMetadataValue(req.ScalerMetadata, "waitList")
+	result, err := s.checkActive(ctx, req, logger)
 	if err != nil {
-		log.Printf("[IsActive] Error getting waitList: %v", err)
-		return &pb.IsActiveResponse{Result: false}, err
+		return &pb.IsActiveResponse{Result: false}, statusError(err)
 	}
+	addResultAttrs(ctx, "result", result)
+	return &pb.IsActiveResponse{Result: result}, nil
+}
+
+// checkActive validates the ScaledObject metadata and reports whether its queue has work.
+// It is shared by IsActive and StreamIsActive so both make the same decision.
+func (s *server) checkActive(ctx context.Context, req *pb.ScaledObjectRef, logger *slog.Logger) (bool, error) {
+	ctx, cancel := s.requestContext(ctx)
+	defer cancel()
 
-	activeList, err := getMetadataValue(req.ScalerMetadata, "activeList")
+	meta, err := validateScalerMetadata(req.Namespace, req.ScalerMetadata)
 	if err != nil {
-		log.Printf("[IsActive] Error getting activeList: %v", err)
-		return &pb.IsActiveResponse{Result: false}, err
+		logger.Warn("Invalid metadata", "error", err)
+		return false, err
 	}
 
-	log.Printf("[IsActive] Using queues: wait='%s', active='%s'", waitList, activeList)
+	result, err := s.readActive(ctx, req, meta, logger)
+	key := meta.staleValueKey(req)
+	if err == nil {
+		if meta.staleValueTTL > 0 {
+			s.queueStates.rememberActive(key, result, time.Now())
+		}
+		return result, nil
+	}
+	if meta.staleValueTTL > 0 && isConnectionFailure(err) {
+		if stale, age, ok := s.queueStates.staleActive(key, time.Now(), meta.staleValueTTL); ok {
+			logger.Warn("Redis read failed, answering with the last good result",
+				"result", stale, "age", age.Round(time.Second), "staleValueTTL", meta.staleValueTTL, "error", err)
+			return stale, nil
+		}
+	}
+	if value, ok := meta.circuitFallback(err); ok {
+		logger.Warn("Redis circuit breaker is open, answering with the fallback",
+			"redisFailureFallback", meta.redisFallback, "result", value > 0, "error", err)
+		return value > 0, nil
+	}
+	return false, err
+}
 
-	waitLen, err := s.redisClient.LLen(ctx, waitList).Result()
+// readActive reads the queue lengths and decides whether the queue has work
+func (s *server) readActive(ctx context.Context, req *pb.ScaledObjectRef, meta scalerMetadata, logger *slog.Logger) (bool, error) {
+	keys := meta.keys
+	logger.Debug("Using queues", keys.logAttrs()...)
+	s.checkKeyTypesOnce(ctx, keys, logger)
+
+	lengths, err := s.readQueueLengths(ctx, keys)
 	if err != nil {
-		log.Printf("[IsActive] Error getting length of wait list '%s': %v", waitList, err)
-		return &pb.IsActiveResponse{Result: false}, err
+		logger.Error("Error reading queue lengths", "error", err)
+		return false, err
 	}
+	total := lengths.total()
 
-	activeLen, err := s.redisClient.LLen(ctx, activeList).Result()
-	if err != nil {
-		log.Printf("[IsActive] Error getting length of active list '%s': %v", activeList, err)
-		return &pb.IsActiveResponse{Result: false}, err
+	s.queueStates.recordActivity(keys.name(), total)
+	recordQueueLengths(req.Namespace, req.Name, keys, lengths)
+	addResultAttrs(ctx, append(lengths.logAttrs(), "total", total, "paused", lengths.paused)...)
+
+	if lengths.paused {
+		logger.Debug("Scaling suppressed: queue is paused", append(lengths.logAttrs(), "total", total, "pausedKey", keys.paused)...)
+		return false, nil
 	}
 
-	result := (waitLen + activeLen) > 0
-	log.Printf("[IsActive] wait=%d, active=%d, total=%d, result=%v", waitLen, activeLen, waitLen+activeLen, result)
-	return &pb.IsActiveResponse{Result: result}, nil
+	// With max aggregation the threshold applies to each queue on its own
+	activity := total
+	if meta.aggregateMax {
+		activity = lengths.maxQueueTotal(defaultQueueWeights)
+	}
+	if meta.scaleOnEvents {
+		if activity, err = s.readEventsBacklog(ctx, s.clientFor(keys.redis), meta.events); err != nil {
+			logger.Error("Error reading events backlog", "error", err)
+			return false, err
+		}
+	}
+	result := activity > meta.activationThreshold
+	addResultAttrs(ctx, "activity", activity)
+	// Scheduled jobs about to fire need a pod ready when they do
+	if !result && !meta.scaleOnEvents && lengths.upcoming > 0 {
+		logger.Debug("Activating ahead of scheduled jobs", "upcoming", lengths.upcoming, "scheduleLookahead", keys.lookahead)
+		result = true
+	}
+	logger.Debug("Activation checked", append(lengths.logAttrs(),
+		"total", total, "activity", activity, "activationThreshold", meta.activationThreshold, "targetSize", meta.targetSize, "result", result)...)
+	return result, nil
 }
 
 // GetMetricSpec returns the metric name and target value for scaling
-func (s *server) GetMetricSpec(ctx context.Context, req *pb.ScaledObjectRef) (*pb.GetMetricSpecResponse, error) {
-	log.Printf("[GetMetricSpec] Called for ScaledObject: %s/%s", req.Namespace, req.Name)
+func (s *server) GetMetricSpec(ctx context.Context, req *pb.ScaledObjectRef) (resp *pb.GetMetricSpecResponse, err error) {
+	defer func() { observeRequest("GetMetricSpec", err) }()
+	logger := requestLogger(ctx, "GetMetricSpec", req)
+	logger.Debug("Called")
+
+	meta, err := validateScalerMetadata(req.Namespace, req.ScalerMetadata)
+	if err != nil {
+		logger.Warn("Invalid metadata", "error", err)
+		return &pb.GetMetricSpecResponse{}, statusError(err)
+	}
 
-	spec := &pb.MetricSpec{
-		MetricName: "bull_queue_length",
-		TargetSize: 1,
+	specs := meta.metricSpecs(req)
+	for _, spec := range specs {
+		logger.Debug("Returning spec", "metricName", spec.MetricName, "targetSize", spec.TargetSizeFloat)
 	}
-	log.Printf("[GetMetricSpec] Returning spec: metricName=%s, targetSize=%d", spec.MetricName, spec.TargetSize)
-	return &pb.GetMetricSpecResponse{
-		MetricSpecs: []*pb.MetricSpec{spec},
-	}, nil
+	addResultAttrs(ctx, "metricName", specs[0].MetricName, "targetSize", specs[0].TargetSizeFloat)
+	return &pb.GetMetricSpecResponse{MetricSpecs: specs}, nil
 }
 
-// GetMetrics returns the current metric value: total jobs in wait+active, capped at maxPods
-func (s *server) GetMetrics(ctx context.Context, req *pb.GetMetricsRequest) (*pb.GetMetricsResponse, error) {
-	log.Printf("[GetMetrics] Called for ScaledObject: %s/%s", req.ScaledObjectRef.Namespace, req.ScaledObjectRef.Name)
+// newMetricSpec returns a metric spec with both forms of its target. KEDA versions that
+// know TargetSizeFloat use it, so a target may be fractional, e.g. 0.5 jobs per pod; older
+// ones read TargetSize, which is rounded up since it must be at least 1.
+func newMetricSpec(name string, target float64) *pb.MetricSpec {
+	return &pb.MetricSpec{MetricName: name, TargetSize: max(1, int64(math.Ceil(target))), TargetSizeFloat: target}
+}
 
-	waitList, err := getMetadataValue(req.ScaledObjectRef.ScalerMetadata, "waitList")
-	if err != nil {
-		log.Printf("[GetMetrics] Error getting waitList: %v", err)
-		return &pb.GetMetricsResponse{}, err
+// newMetricValue returns a metric value with both forms of it: MetricValueFloat keeps the
+// fraction of a smoothed or weighted value, MetricValue rounds it for older KEDA versions
+func newMetricValue(name string, value float64) *pb.MetricValue {
+	return &pb.MetricValue{MetricName: name, MetricValue: int64(math.Round(value)), MetricValueFloat: value}
+}
+
+// metricSpecs returns the metrics the trigger reports, with their targets
+func (m scalerMetadata) metricSpecs(ref *pb.ScaledObjectRef) []*pb.MetricSpec {
+	specs := []*pb.MetricSpec{
+		newMetricSpec(m.lengthMetricName(ref), m.targetSize),
+	}
+	// Listing the raw metric means KEDA scales on the larger of the two, so the maxPods cap
+	// is left to the HPA's replica bounds
+	if m.emitRaw {
+		specs = append(specs, newMetricSpec(m.scopedName(rawLengthMetric, ref), m.targetSize))
+	}
+	// Stalled jobs get their own target, so a pile-up adds capacity even when it is small
+	// next to the backlog
+	if m.stalledTarget > 0 {
+		specs = append(specs, newMetricSpec(m.scopedName(stalledMetric, ref), float64(m.stalledTarget)))
+	}
+	// With an age target the HPA scales on whichever of depth and wait time needs more pods
+	if m.ageTarget > 0 {
+		specs = append(specs, newMetricSpec(m.scopedName(ageMetric, ref), float64(m.ageTarget)))
 	}
+	// A retry storm fails jobs faster than usual; scaling on the failure rate adds workers
+	// for the retries instead of letting them starve the main backlog
+	if m.failedThreshold > 0 {
+		specs = append(specs, newMetricSpec(m.scopedName(failureMetric, ref), float64(m.failedThreshold)))
+	}
+	return specs
+}
 
-	activeList, err := getMetadataValue(req.ScaledObjectRef.ScalerMetadata, "activeList")
+// fallbackMetrics reports value as the main metric and 0 for the others, so the HPA's
+// replica count follows the fallback alone
+func (m scalerMetadata) fallbackMetrics(ref *pb.ScaledObjectRef, value float64) []*pb.MetricValue {
+	specs := m.metricSpecs(ref)
+	values := make([]*pb.MetricValue, 0, len(specs))
+	for i, spec := range specs {
+		var metric float64
+		if i == 0 {
+			metric = value
+		}
+		values = append(values, newMetricValue(spec.MetricName, metric))
+	}
+	return values
+}
+
+// GetMetrics returns the current metric value: total jobs across the queue keys, capped at
+// maxPods when capMetricValue is in effect
+func (s *server) GetMetrics(ctx context.Context, req *pb.GetMetricsRequest) (resp *pb.GetMetricsResponse, err error) {
+	defer func() {
+		observeRequest("GetMetrics", err)
+		s.triggers.recordMetrics(req.ScaledObjectRef, resp, err)
+	}()
+	logger := requestLogger(ctx, "GetMetrics", req.ScaledObjectRef)
+	logger.Debug("Called")
+
+	metricValues, err := s.computeMetrics(ctx, req.ScaledObjectRef, logger)
 	if err != nil {
-		log.Printf("[GetMetrics] Error getting activeList: %v", err)
-		return &pb.GetMetricsResponse{}, err
+		return &pb.GetMetricsResponse{}, statusError(err)
 	}
+	addResultAttrs(ctx, "value", metricValues[0].MetricValueFloat)
+	return &pb.GetMetricsResponse{MetricValues: metricValues}, nil
+}
 
-	maxPodsStr, err := getMetadataValue(req.ScaledObjectRef.ScalerMetadata, "maxPods")
+// computeMetrics validates the ScaledObject metadata and computes its metric values. It is
+// shared by GetMetrics and the dry-run loop so both report the same numbers.
+func (s *server) computeMetrics(ctx context.Context, ref *pb.ScaledObjectRef, logger *slog.Logger) ([]*pb.MetricValue, error) {
+	ctx, cancel := s.requestContext(ctx)
+	defer cancel()
+
+	meta, err := validateScalerMetadata(ref.Namespace, ref.ScalerMetadata)
 	if err != nil {
-		log.Printf("[GetMetrics] Error getting maxPods: %v", err)
-		return &pb.GetMetricsResponse{}, err
+		logger.Warn("Invalid metadata", "error", err)
+		return nil, err
 	}
 
-	maxPods, err := strconv.ParseInt(maxPodsStr, 10, 64)
-	if err != nil || maxPods <= 0 {
-		log.Printf("[GetMetrics] Invalid maxPods value: %s (must be a positive integer)", maxPodsStr)
-		return &pb.GetMetricsResponse{}, fmt.Errorf("maxPods must be a positive integer, got: %s", maxPodsStr)
+	metricValues, err := s.readMetrics(ctx, ref, meta, logger)
+	key := meta.staleValueKey(ref)
+	if err == nil {
+		if meta.staleValueTTL > 0 {
+			s.queueStates.rememberMetrics(key, metricValues, time.Now())
+		}
+		return metricValues, nil
+	}
+	// A short Redis blip would otherwise count as a failed trigger in KEDA and flap replicas
+	if meta.staleValueTTL > 0 && isConnectionFailure(err) {
+		if stale, age, ok := s.queueStates.staleMetrics(key, time.Now(), meta.staleValueTTL); ok {
+			logger.Warn("Redis read failed, reporting the last good values",
+				"age", age.Round(time.Second), "staleValueTTL", meta.staleValueTTL, "error", err)
+			return stale, nil
+		}
+	}
+	if value, ok := meta.circuitFallback(err); ok {
+		logger.Warn("Redis circuit breaker is open, reporting the fallback value",
+			"redisFailureFallback", meta.redisFallback, "value", value, "error", err)
+		return meta.fallbackMetrics(ref, value), nil
 	}
+	return nil, err
+}
 
-	log.Printf("[GetMetrics] Using queues: wait='%s', active='%s', maxPods=%d", waitList, activeList, maxPods)
+// readMetrics reads the queue and computes the trigger's metric values from it
+func (s *server) readMetrics(ctx context.Context, ref *pb.ScaledObjectRef, meta scalerMetadata, logger *slog.Logger) ([]*pb.MetricValue, error) {
+	keys := meta.keys
+	logger.Debug("Using queues", append(keys.logAttrs(), "maxPods", meta.maxPods)...)
+	s.checkKeyTypesOnce(ctx, keys, logger)
 
-	waitLen, err := s.redisClient.LLen(ctx, waitList).Result()
+	lengths, err := s.readQueueLengths(ctx, keys)
 	if err != nil {
-		log.Printf("[GetMetrics] Error getting length of wait list '%s': %v", waitList, err)
-		return &pb.GetMetricsResponse{}, err
+		logger.Error("Error reading queue lengths", "error", err)
+		return nil, err
 	}
 
-	activeLen, err := s.redisClient.LLen(ctx, activeList).Result()
-	if err != nil {
-		log.Printf("[GetMetrics] Error getting length of active list '%s': %v", activeList, err)
-		return &pb.GetMetricsResponse{}, err
+	total := lengths.total()
+	s.queueStates.recordActivity(keys.name(), total)
+	recordQueueLengths(ref.Namespace, ref.Name, keys, lengths)
+	addResultAttrs(ctx, append(lengths.logAttrs(), "total", total, "paused", lengths.paused)...)
+
+	paused := lengths.paused
+
+	// Wait latency is informational, so a failure to read it never fails the request
+	if meta.reportWaitLatency {
+		latency, ok, err := s.readWaitLatency(ctx, keys)
+		switch {
+		case err != nil:
+			logger.Warn("Error reading wait latency", "error", err)
+		case ok:
+			logger.Debug("Approximate wait latency", "latency", latency.Round(time.Millisecond))
+			waitLatencyGauge.WithLabelValues(ref.Namespace, ref.Name).Set(latency.Seconds())
+		}
 	}
 
-	total := waitLen + activeLen
+	// fraction is what rounding took off a weighted or smoothed metricValue, reported in
+	// MetricValueFloat. Every later step that changes the value drops it.
 	metricValue := total
-	if metricValue > maxPods {
-		metricValue = maxPods
+	var fraction float64
+	exact := func() float64 { return float64(metricValue) + fraction }
+	if meta.scaleOnAge {
+		age, err := s.readOldestJobAge(ctx, keys)
+		if err != nil {
+			logger.Error("Error reading oldest job age", "error", err)
+			return nil, err
+		}
+		metricValue = int64(age / time.Second)
+		logger.Debug("Scaling on oldest job age", "ageSeconds", metricValue)
+	} else if meta.scaleOnEvents {
+		if metricValue, err = s.readEventsBacklog(ctx, s.clientFor(keys.redis), meta.events); err != nil {
+			logger.Error("Error reading events backlog", "error", err)
+			return nil, err
+		}
+		logger.Debug("Scaling on events backlog", "eventsStream", meta.events.streams, "eventsConsumerGroup", meta.events.group, "backlog", metricValue)
+	} else if meta.aggregateMax {
+		metricValue = lengths.maxQueueTotal(meta.weights)
+		logger.Debug("Aggregated queues by max", "queues", len(lengths.queues), "max", metricValue, "sum", total)
+	} else if meta.weights != defaultQueueWeights {
+		weighted := lengths.weightedSum(meta.weights)
+		metricValue = int64(math.Round(weighted))
+		fraction = weighted - float64(metricValue)
+		logger.Debug("Applied weights", "waitContribution", float64(lengths.wait+lengths.prioritized+lengths.waitingChildren+lengths.grouped)*meta.weights.wait,
+			"activeContribution", float64(lengths.active+lengths.stalled)*meta.weights.active,
+			"delayedContribution", float64(lengths.countedDelayed())*meta.weights.delayed,
+			"weightedTotal", weighted)
+	}
+	if meta.metricPercentile > 0 {
+		samples := s.queueStates.addSample(keys.name(), metricValue, meta.sampleBufferSize)
+		raw := metricValue
+		metricValue, fraction = percentile(samples, meta.metricPercentile), 0
+		logger.Debug("Applied percentile", "percentile", meta.metricPercentile, "samples", len(samples), "value", metricValue, "raw", raw)
+	}
+	if meta.smoothingFactor > 0 {
+		raw := metricValue
+		smoothed := s.queueStates.smooth(ref.Namespace+"/"+ref.Name, raw, meta.smoothingFactor)
+		metricValue = int64(math.Round(smoothed))
+		fraction = smoothed - float64(metricValue)
+		logger.Debug("Applied smoothing", "raw", raw, "smoothed", smoothed, "smoothingFactor", meta.smoothingFactor)
+	}
+	if meta.smoothingWindow > 0 {
+		raw := metricValue
+		metricValue = s.queueStates.windowMax(ref.Namespace+"/"+ref.Name, raw, time.Now(), meta.smoothingWindow)
+		if metricValue != raw {
+			fraction = 0
+		}
+		logger.Debug("Applied max over smoothing window", "raw", raw, "max", metricValue, "smoothingWindow", meta.smoothingWindow)
+	}
+
+	if !meta.scaleOnAge && !meta.scaleOnEvents && lengths.upcoming > 0 && exact() < 1 {
+		logger.Debug("Reporting 1 ahead of scheduled jobs", "upcoming", lengths.upcoming, "scheduleLookahead", keys.lookahead)
+		metricValue, fraction = 1, 0
+	}
+	// Keep enough workers for in-flight jobs while any are active, so scaling down does not
+	// kill them mid-drain
+	if !meta.scaleOnAge && !meta.scaleOnEvents && lengths.active > 0 && exact() < float64(meta.minActive) {
+		logger.Debug("Applied minActive floor", "raw", metricValue, "minActive", meta.minActive, "activeLen", lengths.active)
+		metricValue, fraction = meta.minActive, 0
+	}
+	// A rate limit that cannot be read leaves the value uncapped rather than failing the
+	// request, since scaling on the full backlog is the safe default
+	if meta.respectRateLimit {
+		limit, ok, err := s.readRateLimit(ctx, s.clientFor(keys.redis), meta.rateLimit)
+		switch {
+		case err != nil:
+			logger.Warn("Error reading rate limit", "error", err)
+		case !ok:
+			logger.Debug("Queue has no rate limit", "rateLimitKey", meta.rateLimit.metaKey)
+		default:
+			if limitCap := rateLimitCap(limit, meta.rateLimit.window); exact() > float64(limitCap) {
+				logger.Debug("Capped metric value at the rate limit", "raw", metricValue, "rateLimitCap", limitCap,
+					"max", limit.max, "duration", limit.duration, "window", meta.rateLimit.window)
+				metricValue, fraction = limitCap, 0
+			}
+		}
+	}
+	if meta.drain.seconds > 0 {
+		metricValue, fraction = s.drainPods(ref, meta, lengths, metricValue, logger), 0
+	}
+	// An age is not a pod count, so only length-based values are capped at maxPods.
+	// Without capMetricValue the limit is left to KEDA's maxReplicaCount.
+	capped := !meta.scaleOnAge && meta.capMetric
+	if capped && exact() > float64(meta.maxPods) {
+		logger.Debug("Capped metric value at maxPods; the HPA sees fewer jobs than are queued",
+			"raw", metricValue, "maxPods", meta.maxPods)
+		metricValue, fraction = meta.maxPods, 0
+	}
+	switch {
+	case paused && meta.pausedBacklog:
+		logger.Debug("Queue is paused, reporting the backlog", append(lengths.logAttrs(), "total", total, "pausedKey", keys.paused)...)
+	case paused:
+		logger.Debug("Scaling suppressed: queue is paused", append(lengths.logAttrs(), "total", total, "pausedKey", keys.paused)...)
+		metricValue, fraction = 0, 0
+	}
+
+	if capped {
+		logger.Debug("Returning metrics", append(lengths.logAttrs(), "total", total, "capped", exact())...)
+	} else {
+		logger.Debug("Returning metrics, capping disabled", append(lengths.logAttrs(), "total", total, "value", exact())...)
+	}
+	metricValues := []*pb.MetricValue{
+		newMetricValue(meta.lengthMetricName(ref), exact()),
 	}
 
-	log.Printf("[GetMetrics] wait=%d, active=%d, total=%d, capped=%d", waitLen, activeLen, total, metricValue)
-	return &pb.GetMetricsResponse{
-		MetricValues: []*pb.MetricValue{
-			{MetricName: "bull_queue_length", MetricValue: metricValue},
-		},
-	}, nil
+	// The raw metric is listed in GetMetricSpec and KEDA scales on the larger value, so a
+	// paused queue must report 0 here too
+	if meta.emitRaw {
+		raw := total
+		if paused && !meta.pausedBacklog {
+			raw = 0
+		}
+		logger.Debug("Emitting uncapped total as a separate metric", "total", total, "value", raw)
+		metricValues = append(metricValues, newMetricValue(meta.scopedName(rawLengthMetric, ref), float64(raw)))
+	}
+
+	if meta.stalledTarget > 0 {
+		stalled := lengths.stalled
+		if paused && !meta.pausedBacklog {
+			stalled = 0
+		}
+		logger.Debug("Emitting stalled jobs as a separate metric", "stalledLen", lengths.stalled, "value", stalled)
+		metricValues = append(metricValues, newMetricValue(meta.scopedName(stalledMetric, ref), float64(stalled)))
+	}
+
+	if meta.ageTarget > 0 {
+		var ageSeconds int64
+		if !paused || meta.pausedBacklog {
+			age, err := s.readOldestJobAge(ctx, keys)
+			if err != nil {
+				logger.Error("Error reading oldest job age", "error", err)
+				return nil, err
+			}
+			ageSeconds = int64(age / time.Second)
+		}
+		logger.Debug("Emitting oldest job age as a separate metric", "ageSeconds", ageSeconds)
+		metricValues = append(metricValues, newMetricValue(meta.scopedName(ageMetric, ref), float64(ageSeconds)))
+	}
+
+	// The failure rate is tracked apart from the completion rate, under its own state key.
+	// Unlike it, it is listed in GetMetricSpec, so a value is always returned: 0 until a
+	// rate is available.
+	if meta.failedThreshold > 0 {
+		var failureRate float64
+		if lengths.finishedErr != nil {
+			logger.Warn("Error reading failed job count", "error", lengths.finishedErr)
+		} else if rate, ok := s.queueStates.completionRate(ref.Namespace+"/"+ref.Name+"\x00failed", lengths.failed, time.Now(), meta.completionWindow); ok && !(paused && !meta.pausedBacklog) {
+			failureRate = rate
+		}
+		logger.Debug("Emitting failure rate as a separate metric", "failed", lengths.failed, "perMinute", failureRate)
+		metricValues = append(metricValues, newMetricValue(meta.scopedName(failureMetric, ref), failureRate))
+	}
+
+	// The completion rate is informational like the delayed value, and a failure to read it
+	// never fails the request. The first poll only seeds the count, so it emits no value.
+	if len(keys.completed) > 0 || len(keys.failed) > 0 {
+		finished := lengths.finished
+		if lengths.finishedErr != nil {
+			logger.Warn("Error reading finished job counts", "error", lengths.finishedErr)
+		} else if rate, ok := s.queueStates.completionRate(ref.Namespace+"/"+ref.Name, finished, time.Now(), meta.completionWindow); ok {
+			logger.Debug("Emitting completion rate as a separate metric", "finished", finished, "perMinute", rate)
+			completionRateGauge.WithLabelValues(ref.Namespace, ref.Name).Set(rate)
+			metricValues = append(metricValues, newMetricValue(meta.scopedName(completionMetric, ref), rate))
+		} else {
+			logger.Debug("No completion rate yet, recorded finished job count", "finished", finished)
+		}
+	}
+
+	// The separate delayed value is informational only: it is not listed in GetMetricSpec,
+	// so KEDA does not scale on it
+	if meta.emitDelayed {
+		logger.Debug("Emitting delayed jobs as a separate metric", "delayedLen", lengths.delayed)
+		metricValues = append(metricValues, newMetricValue(meta.scopedName(delayedMetric, ref), float64(lengths.delayed)))
+	}
+
+	return metricValues, nil
+}
+
+// grpcListenNetwork returns the GRPC_NETWORK to listen on and the bind address to use with
+// it. "tcp", the default, takes both IPv4 and IPv6 connections where the host supports
+// them; "tcp4" and "tcp6" restrict the listener to one family, for clusters where the
+// other is missing or must not be exposed. An IPv6 bind address may be given in brackets.
+func grpcListenNetwork(bindAddress string) (network, address string) {
+	network = getEnvDefault("GRPC_NETWORK", "tcp")
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		fatal("Invalid GRPC_NETWORK: must be tcp, tcp4 or tcp6", "value", network)
+	}
+	address = strings.TrimSuffix(strings.TrimPrefix(bindAddress, "["), "]")
+	if ip := net.ParseIP(address); ip != nil {
+		if network == "tcp4" && ip.To4() == nil {
+			fatal("GRPC_BIND_ADDRESS is an IPv6 address, which GRPC_NETWORK tcp4 cannot listen on", "address", bindAddress)
+		}
+		if network == "tcp6" && ip.To4() != nil {
+			fatal("GRPC_BIND_ADDRESS is an IPv4 address, which GRPC_NETWORK tcp6 cannot listen on", "address", bindAddress)
+		}
+	}
+	return network, address
 }
 
-func main() {
-	port := 8080
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// listenUnixSocket listens on a Unix domain socket, for a scaler running as a sidecar that
+// should not expose a TCP port. A socket file left behind by a previous run that was killed
+// is removed first; any other file at the path is left alone and fails the startup. The
+// listener removes the socket file again when the server stops.
+func listenUnixSocket(path string) net.Listener {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			fatal("GRPC_UNIX_SOCKET path exists and is not a socket", "path", path)
+		}
+		if err := os.Remove(path); err != nil {
+			fatal("Failed to remove stale socket file", "path", path, "error", err)
+		}
+		slog.Info("Removed stale socket file", "path", path)
+	}
+	lis, err := net.Listen("unix", path)
 	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+		fatal("Failed to listen", "socket", path, "error", err)
+	}
+	return lis
+}
+
+// runServe runs the gRPC server until it is shut down; it is the "serve" subcommand
+func runServe(args []string) {
+	// Flags override the matching env vars, for running the binary directly as a sidecar
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	grpcPortFlag := flags.String("grpc-port", "", "gRPC listen port (overrides GRPC_PORT)")
+	grpcBindFlag := flags.String("grpc-bind-address", "", "gRPC bind address, e.g. 127.0.0.1 (overrides GRPC_BIND_ADDRESS)")
+	devRedisFlag := flags.Bool("dev-redis", false, "serve from an in-process Redis, like REDIS_MODE=embedded (devredis builds only)")
+	flags.Parse(args)
+
+	setupLogging()
+	slog.Info("Starting redis-bull-scaler", buildInfoAttrs()...)
+	loadConfigFile()
+	tracingOptions, shutdownTracing := setupTracing()
+
+	metricsPort := getEnvDefault("METRICS_PORT", "9090")
+	if err := validatePortNumber(metricsPort); err != nil {
+		fatal("Invalid METRICS_PORT", "error", err)
+	}
+	startMetricsServer(metricsPort)
+
+	healthPort := getEnvDefault("HEALTH_PORT", "8081")
+	if err := validatePortNumber(healthPort); err != nil {
+		fatal("Invalid HEALTH_PORT", "error", err)
 	}
-	grpcServer := grpc.NewServer()
-	pb.RegisterExternalScalerServer(grpcServer, NewServer())
-	log.Printf("Starting gRPC server on :%d", port)
+
+	if getEnvBool("ENABLE_PPROF", false) {
+		pprofPort := getEnvDefault("PPROF_PORT", "6060")
+		if err := validatePortNumber(pprofPort); err != nil {
+			fatal("Invalid PPROF_PORT", "error", err)
+		}
+		startPprofServer(pprofPort)
+	}
+
+	startEmbeddedRedis(*devRedisFlag)
+	scaler := NewServer()
+	startHealthServer(healthPort, scaler.ready)
+	startDryRun(scaler)
+	if debugPort := os.Getenv("DEBUG_PORT"); debugPort != "" {
+		if err := validatePortNumber(debugPort); err != nil {
+			fatal("Invalid DEBUG_PORT", "error", err)
+		}
+		startDebugServer(debugPort, scaler)
+	}
+
+	port := getEnvDefault("GRPC_PORT", "8080")
+	if *grpcPortFlag != "" {
+		port = *grpcPortFlag
+	}
+	if err := validatePortNumber(port); err != nil {
+		fatal("Invalid GRPC_PORT", "error", err)
+	}
+	// An empty bind address listens on every interface; 127.0.0.1 keeps a scaler running
+	// as a sidecar next to KEDA unreachable from the rest of the cluster
+	bindAddress := os.Getenv("GRPC_BIND_ADDRESS")
+	if *grpcBindFlag != "" {
+		bindAddress = *grpcBindFlag
+	}
+	network, bindAddress := grpcListenNetwork(bindAddress)
+
+	shutdownTimeout := getEnvDuration("SHUTDOWN_TIMEOUT", 10*time.Second)
+	healthInterval := getEnvDuration("GRPC_HEALTH_INTERVAL", 10*time.Second)
+	if healthInterval <= 0 {
+		fatal("Invalid GRPC_HEALTH_INTERVAL: must be greater than zero")
+	}
+
+	var lis net.Listener
+	if socketPath := os.Getenv("GRPC_UNIX_SOCKET"); socketPath != "" {
+		lis = listenUnixSocket(socketPath)
+	} else {
+		var err error
+		lis, err = net.Listen(network, net.JoinHostPort(bindAddress, port))
+		if err != nil {
+			fatal("Failed to listen", "network", network, "address", bindAddress, "port", port, "error", err)
+		}
+	}
+	serverOptions := append(grpcServerOptions(), keepaliveOptions()...)
+	serverOptions = append(serverOptions, interceptorOptions()...)
+	grpcServer := grpc.NewServer(append(serverOptions, tracingOptions...)...)
+	pb.RegisterExternalScalerServer(grpcServer, scaler)
+	healthServer := registerGRPCHealth(grpcServer, scaler.ready, healthInterval)
+	// Reflection lets grpcurl list and call the methods without the proto file. It is off by
+	// default since it describes the API to anyone who can reach the port.
+	if getEnvBool("ENABLE_REFLECTION", false) {
+		reflection.Register(grpcServer)
+		slog.Info("gRPC server reflection enabled")
+	}
+	shutdownDone := handleShutdown(grpcServer, healthServer, shutdownTimeout, scaler.redisClient, scaler.redisPool, scaler.tracking)
+
+	slog.Info("Starting gRPC server", "network", lis.Addr().Network(), "address", lis.Addr().String())
 	if err := grpcServer.Serve(lis); err != nil {
-		log.Fatalf("Failed to serve: %v", err)
+		fatal("Failed to serve", "error", err)
+	}
+
+	// Serve returns as soon as shutdown begins; wait for in-flight RPCs to drain
+	<-shutdownDone
+	flushCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Warn("Failed to flush traces", "error", err)
 	}
+	slog.Info("Shutdown complete")
 }
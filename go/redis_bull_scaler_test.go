@@ -0,0 +1,633 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+)
+
+// errNotFaked is returned by fakeRedis for commands it does not model, so a new read path
+// fails the test using it instead of panicking
+var errNotFaked = errors.New("command not supported by fakeRedis")
+
+// fakeRedis is a redisCmdable holding list, set and sorted set lengths and hash fields,
+// enough for the pipelined queue reads and the pause check
+type fakeRedis struct {
+	mu     sync.Mutex
+	lists  map[string]int64
+	sets   map[string]int64
+	zsets  map[string]int64
+	hashes map[string]map[string]string
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{
+		lists:  make(map[string]int64),
+		sets:   make(map[string]int64),
+		zsets:  make(map[string]int64),
+		hashes: make(map[string]map[string]string),
+	}
+}
+
+// length returns the length of key in m, 0 when it does not exist
+func (f *fakeRedis) length(m map[string]int64, key string) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return m[key]
+}
+
+// keyType returns the TYPE reply for key
+func (f *fakeRedis) keyType(key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case f.lists[key] > 0:
+		return "list"
+	case f.sets[key] > 0:
+		return "set"
+	case f.zsets[key] > 0:
+		return "zset"
+	case f.hashes[key] != nil:
+		return "hash"
+	}
+	return "none"
+}
+
+func (f *fakeRedis) Ping(ctx context.Context) *redis.StatusCmd {
+	return redis.NewStatusResult("PONG", nil)
+}
+
+func (f *fakeRedis) LLen(ctx context.Context, key string) *redis.IntCmd {
+	return redis.NewIntResult(f.length(f.lists, key), nil)
+}
+
+func (f *fakeRedis) LIndex(ctx context.Context, key string, index int64) *redis.StringCmd {
+	return redis.NewStringResult("", errNotFaked)
+}
+
+func (f *fakeRedis) LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
+	return redis.NewStringSliceResult(nil, errNotFaked)
+}
+
+func (f *fakeRedis) ZCard(ctx context.Context, key string) *redis.IntCmd {
+	return redis.NewIntResult(f.length(f.zsets, key), nil)
+}
+
+func (f *fakeRedis) SCard(ctx context.Context, key string) *redis.IntCmd {
+	return redis.NewIntResult(f.length(f.sets, key), nil)
+}
+
+func (f *fakeRedis) XLen(ctx context.Context, stream string) *redis.IntCmd {
+	return redis.NewIntResult(0, errNotFaked)
+}
+
+func (f *fakeRedis) ZRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
+	return redis.NewStringSliceResult(nil, errNotFaked)
+}
+
+func (f *fakeRedis) ZRangeWithScores(ctx context.Context, key string, start, stop int64) *redis.ZSliceCmd {
+	return redis.NewZSliceCmdResult(nil, errNotFaked)
+}
+
+func (f *fakeRedis) HMGet(ctx context.Context, key string, fields ...string) *redis.SliceCmd {
+	return redis.NewSliceResult(nil, errNotFaked)
+}
+
+func (f *fakeRedis) HExists(ctx context.Context, key, field string) *redis.BoolCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.hashes[key][field]
+	return redis.NewBoolResult(ok, nil)
+}
+
+func (f *fakeRedis) Exists(ctx context.Context, keys ...string) *redis.IntCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int64
+	for _, key := range keys {
+		if f.lists[key] > 0 || f.sets[key] > 0 || f.zsets[key] > 0 || f.hashes[key] != nil {
+			n++
+		}
+	}
+	return redis.NewIntResult(n, nil)
+}
+
+func (f *fakeRedis) Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd {
+	cmd := redis.NewScanCmd(ctx, nil)
+	cmd.SetErr(errNotFaked)
+	return cmd
+}
+
+func (f *fakeRedis) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	return redis.NewCmdResult(nil, errNotFaked)
+}
+
+func (f *fakeRedis) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	return redis.NewCmdResult(nil, errNotFaked)
+}
+
+func (f *fakeRedis) ScriptExists(ctx context.Context, hashes ...string) *redis.BoolSliceCmd {
+	return redis.NewBoolSliceResult(nil, errNotFaked)
+}
+
+func (f *fakeRedis) ScriptLoad(ctx context.Context, script string) *redis.StringCmd {
+	return redis.NewStringResult("", errNotFaked)
+}
+
+func (f *fakeRedis) Do(ctx context.Context, args ...interface{}) *redis.Cmd {
+	return redis.NewCmdResult(nil, errNotFaked)
+}
+
+func (f *fakeRedis) Pipeline() redis.Pipeliner {
+	return &fakePipeline{redis: f}
+}
+
+func (f *fakeRedis) Close() error {
+	return nil
+}
+
+// fakePipeline answers the commands of the queue read as they are queued. The read's
+// commands the fake does not model fail with errNotFaked; Pipeliner methods the read never
+// queues are left to the nil embedded interface.
+type fakePipeline struct {
+	redis.Pipeliner
+
+	redis *fakeRedis
+	cmds  []redis.Cmder
+}
+
+func (p *fakePipeline) LLen(ctx context.Context, key string) *redis.IntCmd {
+	cmd := p.redis.LLen(ctx, key)
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+func (p *fakePipeline) ZCard(ctx context.Context, key string) *redis.IntCmd {
+	cmd := p.redis.ZCard(ctx, key)
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+func (p *fakePipeline) SCard(ctx context.Context, key string) *redis.IntCmd {
+	cmd := p.redis.SCard(ctx, key)
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+func (p *fakePipeline) HExists(ctx context.Context, key, field string) *redis.BoolCmd {
+	cmd := p.redis.HExists(ctx, key, field)
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+func (p *fakePipeline) Type(ctx context.Context, key string) *redis.StatusCmd {
+	cmd := redis.NewStatusResult(p.redis.keyType(key), nil)
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+func (p *fakePipeline) ZCount(ctx context.Context, key, min, max string) *redis.IntCmd {
+	cmd := redis.NewIntResult(0, errNotFaked)
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+func (p *fakePipeline) LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
+	cmd := redis.NewStringSliceResult(nil, errNotFaked)
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+func (p *fakePipeline) ZRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
+	cmd := redis.NewStringSliceResult(nil, errNotFaked)
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+func (p *fakePipeline) HGet(ctx context.Context, key, field string) *redis.StringCmd {
+	cmd := redis.NewStringResult("", errNotFaked)
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+func (p *fakePipeline) Len() int {
+	return len(p.cmds)
+}
+
+func (p *fakePipeline) Exec(ctx context.Context) ([]redis.Cmder, error) {
+	for _, cmd := range p.cmds {
+		if err := cmd.Err(); err != nil {
+			return p.cmds, err
+		}
+	}
+	return p.cmds, nil
+}
+
+// newTestServer returns a server on client without length caching, so every call reads
+// the queue
+func newTestServer(client redisCmdable) *server {
+	cfg := defaultServerConfig()
+	cfg.metricCacheTTL = 0
+	return newServer(client, cfg)
+}
+
+// blockingHook counts the commands and pipelines with an LLEN of key and holds them until
+// release is closed, so concurrent reads can join the first one
+type blockingHook struct {
+	key     string
+	reads   atomic.Int64
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+
+	// panics makes the read panic instead of waiting for release
+	panics bool
+}
+
+func (h *blockingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	h.process([]redis.Cmder{cmd})
+	return ctx, nil
+}
+
+func (h *blockingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *blockingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	h.process(cmds)
+	return ctx, nil
+}
+
+func (h *blockingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func (h *blockingHook) process(cmds []redis.Cmder) {
+	for _, cmd := range cmds {
+		if args := cmd.Args(); cmd.Name() == "llen" && len(args) == 2 && args[1] == h.key {
+			h.reads.Add(1)
+			if h.panics {
+				panic("read failed")
+			}
+			h.once.Do(func() { close(h.started) })
+			<-h.release
+			return
+		}
+	}
+}
+
+// waitForGoroutines waits until n goroutines have a stack containing every one of frames,
+// so a test knows concurrent callers are parked before it lets them go on
+func waitForGoroutines(t *testing.T, n int, frames ...string) {
+	t.Helper()
+	buf := make([]byte, 1<<20)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		count := 0
+	stacks:
+		for _, stack := range strings.Split(string(buf[:runtime.Stack(buf, true)]), "\n\n") {
+			for _, frame := range frames {
+				if !strings.Contains(stack, frame) {
+					continue stacks
+				}
+			}
+			count++
+		}
+		if count >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d goroutines reached %v", count, n, frames)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConcurrentReadsShareOneRoundTrip(t *testing.T) {
+	const callers = 20
+
+	s, hook, req := newBlockingServer(t)
+	values := make(chan int64, callers)
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			resp, err := s.GetMetrics(context.Background(), req)
+			if err != nil {
+				errs <- err
+				return
+			}
+			values <- resp.MetricValues[0].MetricValue
+		}()
+	}
+
+	// hold the first read open until every other caller waits on it
+	select {
+	case <-hook.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the queue read")
+	}
+	waitForGoroutines(t, callers, "[select", "(*server).readQueueLengths(")
+	close(hook.release)
+
+	for i := 0; i < callers; i++ {
+		select {
+		case err := <-errs:
+			t.Fatalf("GetMetrics: %v", err)
+		case v := <-values:
+			if v != 7 {
+				t.Errorf("GetMetrics value = %d, want 7", v)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for GetMetrics")
+		}
+	}
+	if got := hook.reads.Load(); got != 1 {
+		t.Errorf("queue reads = %d, want 1", got)
+	}
+}
+
+func TestIsActive(t *testing.T) {
+	tests := []struct {
+		name     string
+		lists    map[string]int64
+		zsets    map[string]int64
+		hashes   map[string]map[string]string
+		metadata map[string]string
+		want     bool
+		wantCode codes.Code
+	}{
+		{name: "empty queue", metadata: map[string]string{"queueName": "emails", "maxPods": "10"}, want: false},
+		{name: "waiting jobs", lists: map[string]int64{"bull:emails:wait": 3}, metadata: map[string]string{"queueName": "emails", "maxPods": "10"}, want: true},
+		{name: "active jobs only", lists: map[string]int64{"bull:emails:active": 1}, metadata: map[string]string{"queueName": "emails", "maxPods": "10"}, want: true},
+		{name: "prioritized jobs only", zsets: map[string]int64{"bull:emails:prioritized": 2}, metadata: map[string]string{"queueName": "emails", "maxPods": "10"}, want: true},
+		{
+			name:     "paused queue",
+			lists:    map[string]int64{"bull:emails:paused": 3},
+			hashes:   map[string]map[string]string{"bull:emails:meta": {"paused": "1"}},
+			metadata: map[string]string{"queueName": "emails", "maxPods": "10", "pausedKey": "bull:emails:meta"},
+			want:     false,
+		},
+		{
+			name:     "paused queue reporting its backlog",
+			lists:    map[string]int64{"bull:emails:paused": 3},
+			hashes:   map[string]map[string]string{"bull:emails:meta": {"paused": "1"}},
+			metadata: map[string]string{"queueName": "emails", "maxPods": "10", "pausedKey": "bull:emails:meta", "pausedMetric": "backlog"},
+			want:     false,
+		},
+		{name: "below activation threshold", lists: map[string]int64{"bull:emails:wait": 2}, metadata: map[string]string{"queueName": "emails", "maxPods": "10", "activationThreshold": "5"}, want: false},
+		{name: "missing metadata", metadata: map[string]string{}, wantCode: codes.InvalidArgument},
+		{name: "invalid activation threshold", metadata: map[string]string{"queueName": "emails", "maxPods": "10", "activationThreshold": "-1"}, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb := newFakeRedis()
+			for key, n := range tt.lists {
+				rdb.lists[key] = n
+			}
+			for key, n := range tt.zsets {
+				rdb.zsets[key] = n
+			}
+			for key, fields := range tt.hashes {
+				rdb.hashes[key] = fields
+			}
+			s := newTestServer(rdb)
+
+			resp, err := s.IsActive(context.Background(), &pb.ScaledObjectRef{Name: "emails", Namespace: "default", ScalerMetadata: tt.metadata})
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Fatalf("IsActive error = %v, want code %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("IsActive: %v", err)
+			}
+			if resp.Result != tt.want {
+				t.Errorf("IsActive = %v, want %v", resp.Result, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetMetrics(t *testing.T) {
+	tests := []struct {
+		name     string
+		lists    map[string]int64
+		zsets    map[string]int64
+		hashes   map[string]map[string]string
+		metadata map[string]string
+		want     int64
+		wantCode codes.Code
+	}{
+		{name: "empty queue", metadata: map[string]string{"queueName": "emails", "maxPods": "10"}, want: 0},
+		{
+			name:     "wait and active",
+			lists:    map[string]int64{"bull:emails:wait": 4, "bull:emails:active": 2},
+			metadata: map[string]string{"queueName": "emails", "maxPods": "10"},
+			want:     6,
+		},
+		{
+			name:     "delayed jobs not counted by default",
+			lists:    map[string]int64{"bull:emails:wait": 1},
+			zsets:    map[string]int64{"bull:emails:delayed": 2, "bull:emails:prioritized": 3},
+			metadata: map[string]string{"queueName": "emails", "maxPods": "10"},
+			want:     4,
+		},
+		{
+			name:     "delayed jobs included",
+			lists:    map[string]int64{"bull:emails:wait": 1},
+			zsets:    map[string]int64{"bull:emails:delayed": 2, "bull:emails:prioritized": 3},
+			metadata: map[string]string{"queueName": "emails", "maxPods": "10", "includeDelayed": "all"},
+			want:     6,
+		},
+		{
+			name:     "explicit delayed set",
+			lists:    map[string]int64{"jobs:wait": 1},
+			zsets:    map[string]int64{"jobs:delayed": 2},
+			metadata: map[string]string{"waitList": "jobs:wait", "activeList": "jobs:active", "delayedSet": "jobs:delayed", "maxPods": "10"},
+			want:     3,
+		},
+		{
+			name:     "explicit delayed set left out",
+			lists:    map[string]int64{"jobs:wait": 1},
+			zsets:    map[string]int64{"jobs:delayed": 2},
+			metadata: map[string]string{"waitList": "jobs:wait", "activeList": "jobs:active", "delayedSet": "jobs:delayed", "maxPods": "10", "includeDelayed": "none"},
+			want:     1,
+		},
+		{
+			// The fake keeps no scores, so the ZCOUNT of due jobs fails like an unreachable Redis
+			name:     "due delayed jobs",
+			lists:    map[string]int64{"bull:emails:wait": 1},
+			zsets:    map[string]int64{"bull:emails:delayed": 2},
+			metadata: map[string]string{"queueName": "emails", "maxPods": "10", "includeDelayed": "due"},
+			wantCode: codes.Unavailable,
+		},
+		{
+			name:     "capped at maxPods",
+			lists:    map[string]int64{"bull:emails:wait": 20},
+			metadata: map[string]string{"queueName": "emails", "maxPods": "5"},
+			want:     5,
+		},
+		{
+			name:     "below maxPods",
+			lists:    map[string]int64{"bull:emails:wait": 3},
+			metadata: map[string]string{"queueName": "emails", "maxPods": "5"},
+			want:     3,
+		},
+		{
+			name:     "paused queue",
+			lists:    map[string]int64{"bull:emails:paused": 3},
+			hashes:   map[string]map[string]string{"bull:emails:meta": {"paused": "1"}},
+			metadata: map[string]string{"queueName": "emails", "maxPods": "10", "pausedKey": "bull:emails:meta"},
+			want:     0,
+		},
+		{
+			name:     "paused queue reporting its backlog",
+			lists:    map[string]int64{"bull:emails:paused": 3, "bull:emails:active": 1},
+			hashes:   map[string]map[string]string{"bull:emails:meta": {"paused": "1"}},
+			metadata: map[string]string{"queueName": "emails", "maxPods": "10", "pausedKey": "bull:emails:meta", "pausedMetric": "backlog"},
+			want:     4,
+		},
+		{
+			name:     "resumed queue",
+			lists:    map[string]int64{"bull:emails:wait": 3, "bull:emails:paused": 2},
+			hashes:   map[string]map[string]string{"bull:emails:meta": {"concurrency": "1"}},
+			metadata: map[string]string{"queueName": "emails", "maxPods": "10", "pausedKey": "bull:emails:meta"},
+			want:     3,
+		},
+		{name: "missing metadata", metadata: map[string]string{}, wantCode: codes.InvalidArgument},
+		{name: "invalid maxPods", metadata: map[string]string{"queueName": "emails", "maxPods": "many"}, wantCode: codes.InvalidArgument},
+		{name: "negative maxPods", metadata: map[string]string{"queueName": "emails", "maxPods": "-1"}, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb := newFakeRedis()
+			for key, n := range tt.lists {
+				rdb.lists[key] = n
+			}
+			for key, n := range tt.zsets {
+				rdb.zsets[key] = n
+			}
+			for key, fields := range tt.hashes {
+				rdb.hashes[key] = fields
+			}
+			s := newTestServer(rdb)
+
+			resp, err := s.GetMetrics(context.Background(), &pb.GetMetricsRequest{
+				ScaledObjectRef: &pb.ScaledObjectRef{Name: "emails", Namespace: "default", ScalerMetadata: tt.metadata},
+			})
+			if tt.wantCode != codes.OK {
+				if err == nil || status.Code(err) != tt.wantCode {
+					t.Fatalf("GetMetrics error = %v, want code %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetMetrics: %v", err)
+			}
+			if len(resp.MetricValues) == 0 {
+				t.Fatal("GetMetrics returned no metric values")
+			}
+			if got := resp.MetricValues[0].MetricValue; got != tt.want {
+				t.Errorf("GetMetrics value = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// newBlockingServer returns a server on a miniredis whose wait list holds 7 jobs, with
+// the read of that list held by the returned hook, and the request reading it
+func newBlockingServer(t *testing.T) (*server, *blockingHook, *pb.GetMetricsRequest) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	for i := 0; i < 7; i++ {
+		mr.Lpush("bull:emails:wait", fmt.Sprint(i))
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	hook := &blockingHook{key: "bull:emails:wait", started: make(chan struct{}), release: make(chan struct{})}
+	rdb.AddHook(hook)
+	req := &pb.GetMetricsRequest{
+		ScaledObjectRef: &pb.ScaledObjectRef{Name: "emails", Namespace: "default", ScalerMetadata: map[string]string{
+			"waitList": "bull:emails:wait", "activeList": "bull:emails:active", "maxPods": "10",
+		}},
+	}
+	return newTestServer(rdb), hook, req
+}
+
+func TestSharedReadOutlivesCancelledStarter(t *testing.T) {
+	s, hook, req := newBlockingServer(t)
+
+	starterCtx, cancelStarter := context.WithTimeout(context.Background(), time.Minute)
+	starterErr := make(chan error, 1)
+	go func() {
+		_, err := s.GetMetrics(starterCtx, req)
+		starterErr <- err
+	}()
+	select {
+	case <-hook.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the queue read")
+	}
+
+	joined := make(chan *pb.GetMetricsResponse, 1)
+	joinedErr := make(chan error, 1)
+	go func() {
+		resp, err := s.GetMetrics(context.Background(), req)
+		if err != nil {
+			joinedErr <- err
+			return
+		}
+		joined <- resp
+	}()
+	waitForGoroutines(t, 2, "[select", "(*server).readQueueLengths(")
+
+	// KEDA abandons the call that started the read
+	cancelStarter()
+	select {
+	case err := <-starterErr:
+		if err == nil {
+			t.Error("cancelled GetMetrics returned no error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled GetMetrics did not return")
+	}
+	close(hook.release)
+
+	select {
+	case err := <-joinedErr:
+		t.Fatalf("joined GetMetrics: %v", err)
+	case resp := <-joined:
+		if got := resp.MetricValues[0].MetricValue; got != 7 {
+			t.Errorf("joined GetMetrics value = %d, want 7", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the joined GetMetrics")
+	}
+	if got := hook.reads.Load(); got != 1 {
+		t.Errorf("queue reads = %d, want 1", got)
+	}
+}
+
+func TestSharedReadPanicIsAnError(t *testing.T) {
+	s, hook, req := newBlockingServer(t)
+	hook.panics = true
+
+	_, err := s.GetMetrics(context.Background(), req)
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("GetMetrics error = %v, want code %s", err, codes.Internal)
+	}
+}
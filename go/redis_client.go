@@ -0,0 +1,591 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/grpc/codes"
+)
+
+// redisCmdable is the subset of go-redis commands the scaler uses. Both *redis.Client and
+// *redis.ClusterClient satisfy it, so the RPC handlers are shared by both connection modes,
+// and newServer accepts any implementation, such as a miniredis-backed client.
+type redisCmdable interface {
+	Ping(ctx context.Context) *redis.StatusCmd
+	LLen(ctx context.Context, key string) *redis.IntCmd
+	LIndex(ctx context.Context, key string, index int64) *redis.StringCmd
+	LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd
+	ZCard(ctx context.Context, key string) *redis.IntCmd
+	SCard(ctx context.Context, key string) *redis.IntCmd
+	XLen(ctx context.Context, stream string) *redis.IntCmd
+	ZRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd
+	ZRangeWithScores(ctx context.Context, key string, start, stop int64) *redis.ZSliceCmd
+	HMGet(ctx context.Context, key string, fields ...string) *redis.SliceCmd
+	HExists(ctx context.Context, key, field string) *redis.BoolCmd
+	Exists(ctx context.Context, keys ...string) *redis.IntCmd
+	Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd
+	EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd
+	ScriptExists(ctx context.Context, hashes ...string) *redis.BoolSliceCmd
+	ScriptLoad(ctx context.Context, script string) *redis.StringCmd
+	Do(ctx context.Context, args ...interface{}) *redis.Cmd
+	Pipeline() redis.Pipeliner
+	Close() error
+}
+
+// redisConnConfig holds the connection settings shared by standalone and cluster clients
+type redisConnConfig struct {
+	username  string
+	password  string
+	onConnect func(ctx context.Context, cn *redis.Conn) error
+	tlsConfig *tls.Config
+
+	// maxConnAge closes connections after this age, so they authenticate again; zero
+	// keeps connections open indefinitely
+	maxConnAge time.Duration
+
+	// Client-level retries of failed commands, with exponential backoff between attempts
+	maxRetries      int
+	minRetryBackoff time.Duration
+	maxRetryBackoff time.Duration
+
+	// Connection pool sizing; zero values keep the go-redis defaults
+	poolSize     int
+	minIdleConns int
+	poolTimeout  time.Duration
+
+	// Socket timeouts; zero values keep the go-redis defaults
+	dialTimeout  time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+// Values of REDIS_AUTH_MODE, how the default client authenticates
+const (
+	redisAuthPassword = "password"
+	redisAuthAWSIAM   = "aws-iam"
+	redisAuthEntra    = "azure-entra"
+)
+
+// redisAuthMode returns REDIS_AUTH_MODE. When it is unset, REDIS_IAM_AUTH=true, the
+// setting it replaces, still selects aws-iam.
+func redisAuthMode() string {
+	mode := os.Getenv("REDIS_AUTH_MODE")
+	if mode == "" {
+		if getEnvBool("REDIS_IAM_AUTH", false) {
+			return redisAuthAWSIAM
+		}
+		return redisAuthPassword
+	}
+	switch mode {
+	case redisAuthPassword, redisAuthAWSIAM, redisAuthEntra:
+		return mode
+	}
+	fatal("Invalid REDIS_AUTH_MODE: must be \"password\", \"aws-iam\" or \"azure-entra\"", "value", mode)
+	return ""
+}
+
+// defaultRedisCluster is set when the default client is a cluster client, whose single
+// database rules out redisDb without a host
+var defaultRedisCluster atomic.Bool
+
+// connectRedis creates the cluster client selected by REDIS_CLUSTER_ADDRS, the Sentinel
+// failover client selected by REDIS_SENTINEL_ADDRS, or a standalone client, and checks it
+// with a Ping bounded by opTimeout. Rejected credentials and a missing database fail fast,
+// since retrying cannot fix them. Any other Ping error is returned with the client, so the
+// scaler can start while Redis is still coming up. tlsConfig is nil for plaintext.
+func connectRedis(opTimeout time.Duration, tlsConfig *tls.Config) (redisCmdable, error) {
+	cfg := &redisConnConfig{
+		username:  getSecretEnv("REDIS_USERNAME"),
+		password:  getSecretEnv("REDIS_PASSWORD"),
+		tlsConfig: tlsConfig,
+	}
+
+	db, err := strconv.Atoi(getEnvDefault("REDIS_DB", "0"))
+	if err != nil || db < 0 {
+		fatal("Invalid REDIS_DB: must be a non-negative integer", "value", os.Getenv("REDIS_DB"))
+	}
+
+	cfg.maxRetries, err = strconv.Atoi(getEnvDefault("REDIS_MAX_RETRIES", "3"))
+	if err != nil || cfg.maxRetries < 0 {
+		fatal("Invalid REDIS_MAX_RETRIES: must be a non-negative integer", "value", os.Getenv("REDIS_MAX_RETRIES"))
+	}
+	// go-redis treats 0 as "use the default" and -1 as "no retries"
+	if cfg.maxRetries == 0 {
+		cfg.maxRetries = -1
+	}
+	cfg.minRetryBackoff = getEnvDuration("REDIS_MIN_RETRY_BACKOFF", 8*time.Millisecond)
+	cfg.maxRetryBackoff = getEnvDuration("REDIS_MAX_RETRY_BACKOFF", 512*time.Millisecond)
+	if cfg.minRetryBackoff > cfg.maxRetryBackoff {
+		fatal("Invalid REDIS_MIN_RETRY_BACKOFF: must not exceed REDIS_MAX_RETRY_BACKOFF",
+			"min", cfg.minRetryBackoff, "max", cfg.maxRetryBackoff)
+	}
+
+	if val := os.Getenv("REDIS_POOL_SIZE"); val != "" {
+		cfg.poolSize, err = strconv.Atoi(val)
+		if err != nil || cfg.poolSize <= 0 {
+			fatal("Invalid REDIS_POOL_SIZE: must be a positive integer", "value", val)
+		}
+	}
+	cfg.minIdleConns, err = strconv.Atoi(getEnvDefault("REDIS_MIN_IDLE_CONNS", "0"))
+	if err != nil || cfg.minIdleConns < 0 {
+		fatal("Invalid REDIS_MIN_IDLE_CONNS: must be a non-negative integer", "value", os.Getenv("REDIS_MIN_IDLE_CONNS"))
+	}
+	cfg.poolTimeout = getEnvDuration("REDIS_POOL_TIMEOUT", 0)
+	cfg.dialTimeout = getEnvDuration("REDIS_DIAL_TIMEOUT", 0)
+	cfg.readTimeout = getEnvDuration("REDIS_READ_TIMEOUT", 0)
+	cfg.writeTimeout = getEnvDuration("REDIS_WRITE_TIMEOUT", 0)
+
+	authMode := redisAuthMode()
+
+	var client redisCmdable
+	var target string
+	// Listing cluster seed nodes is enough to select cluster mode
+	clusterEnabled := getEnvBool("REDIS_CLUSTER_ENABLED", os.Getenv("REDIS_CLUSTER_ADDRS") != "")
+	sentinelAddrs := os.Getenv("REDIS_SENTINEL_ADDRS")
+	if sentinelAddrs != "" && clusterEnabled {
+		fatal("REDIS_SENTINEL_ADDRS cannot be combined with Redis Cluster mode")
+	}
+	redisURL := getSecretEnv("REDIS_URL")
+	if redisURL != "" && (clusterEnabled || sentinelAddrs != "") {
+		fatal("REDIS_URL cannot be combined with Redis Cluster or Sentinel mode")
+	}
+	redisAddresses := os.Getenv("REDIS_ADDRESSES")
+	if redisAddresses != "" && (clusterEnabled || sentinelAddrs != "") {
+		fatal("REDIS_ADDRESSES cannot be combined with Redis Cluster or Sentinel mode, which follow failovers on their own")
+	}
+	defaultRedisCluster.Store(clusterEnabled)
+	// Queue lengths tolerate slight replication lag, so reads can spare the primary
+	readFromReplicas := getEnvBool("REDIS_READ_FROM_REPLICAS", false)
+	if os.Getenv("REDIS_REPLICA_ADDRS") != "" && (clusterEnabled || sentinelAddrs != "") {
+		fatal("REDIS_REPLICA_ADDRS is for a standalone Redis; cluster and Sentinel modes find replicas with REDIS_READ_FROM_REPLICAS")
+	}
+	if clusterEnabled {
+		if db != 0 {
+			fatal("Invalid REDIS_DB: Redis Cluster only supports database 0", "db", db)
+		}
+		addrs := parseHostPortList("REDIS_CLUSTER_ADDRS", getEnv("REDIS_CLUSTER_ADDRS"))
+		host, _, _ := net.SplitHostPort(addrs[0])
+		switch authMode {
+		case redisAuthAWSIAM:
+			configureIAMAuth(cfg, host)
+		case redisAuthEntra:
+			configureEntraAuth(cfg, host)
+		}
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:           addrs,
+			ReadOnly:        readFromReplicas,
+			Username:        cfg.username,
+			Password:        cfg.password,
+			OnConnect:       cfg.onConnect,
+			MaxConnAge:      cfg.maxConnAge,
+			TLSConfig:       cfg.tlsConfig,
+			MaxRetries:      cfg.maxRetries,
+			MinRetryBackoff: cfg.minRetryBackoff,
+			MaxRetryBackoff: cfg.maxRetryBackoff,
+			PoolSize:        cfg.poolSize,
+			MinIdleConns:    cfg.minIdleConns,
+			PoolTimeout:     cfg.poolTimeout,
+			DialTimeout:     cfg.dialTimeout,
+			ReadTimeout:     cfg.readTimeout,
+			WriteTimeout:    cfg.writeTimeout,
+		})
+		target = strings.Join(addrs, ",")
+	} else if sentinelAddrs != "" {
+		// The failover client asks the sentinels for the current master and reconnects to
+		// the new one after a failover, so no restart is needed
+		addrs := parseHostPortList("REDIS_SENTINEL_ADDRS", sentinelAddrs)
+		masterName := getEnv("REDIS_MASTER_NAME")
+		if authMode != redisAuthPassword {
+			fatal("REDIS_AUTH_MODE " + authMode + " is not supported with REDIS_SENTINEL_ADDRS")
+		}
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       masterName,
+			SentinelAddrs:    addrs,
+			SentinelPassword: getSecretEnv("REDIS_SENTINEL_PASSWORD"),
+			SlaveOnly:        readFromReplicas,
+			DB:               db,
+			Username:         cfg.username,
+			Password:         cfg.password,
+			OnConnect:        cfg.onConnect,
+			MaxConnAge:       cfg.maxConnAge,
+			TLSConfig:        cfg.tlsConfig,
+			MaxRetries:       cfg.maxRetries,
+			MinRetryBackoff:  cfg.minRetryBackoff,
+			MaxRetryBackoff:  cfg.maxRetryBackoff,
+			PoolSize:         cfg.poolSize,
+			MinIdleConns:     cfg.minIdleConns,
+			PoolTimeout:      cfg.poolTimeout,
+			DialTimeout:      cfg.dialTimeout,
+			ReadTimeout:      cfg.readTimeout,
+			WriteTimeout:     cfg.writeTimeout,
+		})
+		target = fmt.Sprintf("%s via sentinels %s", masterName, strings.Join(addrs, ","))
+	} else {
+		var redisHost, redisPort string
+		var failoverAddrs []string
+		if redisAddresses != "" {
+			if redisURL != "" || os.Getenv("REDIS_HOST") != "" || os.Getenv("REDIS_PORT") != "" {
+				fatal("REDIS_ADDRESSES cannot be combined with REDIS_HOST, REDIS_PORT or REDIS_URL")
+			}
+			failoverAddrs = parseHostPortList("REDIS_ADDRESSES", redisAddresses)
+			redisHost, redisPort, _ = net.SplitHostPort(failoverAddrs[0])
+		} else if redisURL != "" {
+			if os.Getenv("REDIS_HOST") != "" || os.Getenv("REDIS_PORT") != "" {
+				fatal("REDIS_URL cannot be combined with REDIS_HOST or REDIS_PORT")
+			}
+			opts, err := parseRedisURL(redisURL)
+			if err != nil {
+				fatal("Invalid REDIS_URL", "error", err)
+			}
+			redisHost, redisPort, _ = net.SplitHostPort(opts.Addr)
+			// The separate env vars win over the URL, so a secret can hold the password alone
+			if os.Getenv("REDIS_DB") == "" {
+				db = opts.DB
+			}
+			if cfg.username == "" {
+				cfg.username = opts.Username
+			}
+			if cfg.password == "" {
+				cfg.password = opts.Password
+			}
+			if cfg.tlsConfig == nil {
+				cfg.tlsConfig = opts.TLSConfig
+			}
+		} else {
+			redisHost = getEnv("REDIS_HOST")
+			redisPort = getEnv("REDIS_PORT")
+		}
+		if readFromReplicas && os.Getenv("REDIS_REPLICA_ADDRS") == "" {
+			fatal("REDIS_READ_FROM_REPLICAS requires REDIS_REPLICA_ADDRS for a standalone Redis")
+		}
+
+		// Validate port number
+		if err := validatePortNumber(redisPort); err != nil {
+			fatal("Invalid REDIS_PORT", "error", err)
+		}
+
+		switch authMode {
+		case redisAuthAWSIAM:
+			configureIAMAuth(cfg, redisHost)
+		case redisAuthEntra:
+			configureEntraAuth(cfg, redisHost)
+		}
+		opts := &redis.Options{
+			Addr:            fmt.Sprintf("%s:%s", redisHost, redisPort),
+			DB:              db,
+			Username:        cfg.username,
+			Password:        cfg.password,
+			OnConnect:       cfg.onConnect,
+			MaxConnAge:      cfg.maxConnAge,
+			TLSConfig:       cfg.tlsConfig,
+			MaxRetries:      cfg.maxRetries,
+			MinRetryBackoff: cfg.minRetryBackoff,
+			MaxRetryBackoff: cfg.maxRetryBackoff,
+			PoolSize:        cfg.poolSize,
+			MinIdleConns:    cfg.minIdleConns,
+			PoolTimeout:     cfg.poolTimeout,
+			DialTimeout:     cfg.dialTimeout,
+			ReadTimeout:     cfg.readTimeout,
+			WriteTimeout:    cfg.writeTimeout,
+		}
+		target = opts.Addr
+		// With several endpoints the dialer follows the first healthy one
+		if len(failoverAddrs) > 1 {
+			interval := getEnvDuration("REDIS_FAILOVER_CHECK_INTERVAL", 5*time.Second)
+			if interval <= 0 {
+				fatal("Invalid REDIS_FAILOVER_CHECK_INTERVAL: must be positive", "value", interval)
+			}
+			failover := startRedisFailover(failoverAddrs, opts, interval, opTimeout)
+			opts.Dialer = failover.dial
+			target = failover.activeAddr()
+		}
+		client = redis.NewClient(opts)
+	}
+
+	instrumentRedis(client)
+
+	// Test Redis connection
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	pingErr := client.Ping(ctx).Err()
+	if pingErr != nil {
+		if isAuthError(pingErr) {
+			fatal("Redis authentication failed (check REDIS_USERNAME/REDIS_PASSWORD)", "username", cfg.username, "error", pingErr)
+		}
+		if strings.Contains(pingErr.Error(), "DB index is out of range") {
+			fatal("Invalid REDIS_DB: database does not exist on the server (default Redis config allows 0-15)", "db", db, "error", pingErr)
+		}
+		slog.Warn("Redis is not reachable yet; starting anyway and retrying in the background",
+			"address", target, "db", db, "error", pingErr)
+	} else {
+		slog.Info("Connected to Redis", "address", target, "db", db, "tls", cfg.tlsConfig != nil)
+		if cfg.password != "" {
+			slog.Info("Authenticated to Redis", "username", cfg.username)
+		}
+	}
+	// Log the effective pool and timeout settings, which include go-redis defaults for unset values
+	switch c := client.(type) {
+	case *redis.Client:
+		opts := c.Options()
+		slog.Info("Redis connection pool", "poolSize", opts.PoolSize, "minIdleConns", opts.MinIdleConns, "poolTimeout", opts.PoolTimeout,
+			"dialTimeout", opts.DialTimeout, "readTimeout", opts.ReadTimeout, "writeTimeout", opts.WriteTimeout)
+	case *redis.ClusterClient:
+		opts := c.Options()
+		slog.Info("Redis connection pool (per node)", "poolSize", opts.PoolSize, "minIdleConns", opts.MinIdleConns, "poolTimeout", opts.PoolTimeout,
+			"dialTimeout", opts.DialTimeout, "readTimeout", opts.ReadTimeout, "writeTimeout", opts.WriteTimeout)
+		if pingErr == nil {
+			slog.Info("Redis Cluster mode enabled", "nodes", countClusterNodes(c, opTimeout))
+		}
+	}
+
+	return client, pingErr
+}
+
+// Backoff bounds for the startup reconnect loop
+const (
+	redisConnectMinBackoff = 500 * time.Millisecond
+	redisConnectMaxBackoff = 30 * time.Second
+)
+
+// redisConnState tracks whether the default Redis client has answered a Ping since
+// startup. Once it has, go-redis replaces broken connections on its own, so the state
+// never goes back.
+type redisConnState struct {
+	mu        sync.Mutex
+	connected bool
+	lastErr   error
+}
+
+// set records the result of a startup Ping
+func (c *redisConnState) set(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = err == nil
+	c.lastErr = err
+}
+
+// err returns nil once connected, and otherwise an Unavailable error with the last Ping
+// failure, so KEDA reports why the scaler cannot answer yet
+func (c *redisConnState) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.connected {
+		return nil
+	}
+	return errorWithInfo(codes.Unavailable, fmt.Sprintf("not connected to Redis yet: %v", c.lastErr),
+		reasonRedisNotConnected, nil)
+}
+
+// awaitRedis Pings the default client with exponential backoff until it answers
+func (s *server) awaitRedis() {
+	backoff := redisConnectMinBackoff
+	for {
+		time.Sleep(backoff)
+		err := s.redisOp(context.Background(), "ping", func(ctx context.Context) error {
+			return s.redisClient.Ping(ctx).Err()
+		})
+		s.redisConn.set(err)
+		if err == nil {
+			slog.Info("Connected to Redis")
+			s.startupAccessCheck()
+			return
+		}
+		backoff = min(backoff*2, redisConnectMaxBackoff)
+		slog.Warn("Redis is still not reachable", "retryIn", backoff, "error", err)
+	}
+}
+
+// getSecretEnv returns a credential from the env var key, or from the file named by
+// key_FILE, such as a mounted Kubernetes secret. Setting both fails fast. Credentials often
+// come from mounted secrets with trailing newlines, so the value is trimmed.
+func getSecretEnv(key string) string {
+	value := os.Getenv(key)
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		value = strings.TrimSpace(value)
+		registerSecret(value)
+		return value
+	}
+	if value != "" {
+		fatal("Only one of " + key + " and " + key + "_FILE may be set")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fatal("Failed to read "+key+"_FILE", "path", path, "error", err)
+	}
+	value = strings.TrimSpace(string(data))
+	registerSecret(value)
+	return value
+}
+
+// parseRedisURL parses a redis:// or rediss:// URL, as PaaS providers hand them out, into
+// its address, database, credentials and, for rediss, TLS settings. The password is
+// registered for redaction, since it is not a value of its own env var or metadata key.
+func parseRedisURL(value string) (*redis.Options, error) {
+	opts, err := redis.ParseURL(value)
+	if err != nil {
+		// A url.Error quotes the whole URL, password included
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, err
+	}
+	registerSecret(opts.Password)
+	if opts.TLSConfig != nil {
+		opts.TLSConfig.MinVersion = tls.VersionTLS12
+	}
+	return opts, nil
+}
+
+// redisTLSConfig builds the client TLS settings for Redis. REDIS_TLS_CA_FILE adds a CA
+// bundle to trust instead of the system roots, REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE
+// present a client certificate, and REDIS_TLS_INSECURE_SKIP_VERIFY disables server
+// certificate checks for testing. Unreadable files fail fast. The server name is taken
+// from each address being dialed, so one config serves every cluster node.
+func redisTLSConfig() *tls.Config {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: getEnvBool("REDIS_TLS_INSECURE_SKIP_VERIFY", false),
+	}
+	if tlsConfig.InsecureSkipVerify {
+		slog.Warn("REDIS_TLS_INSECURE_SKIP_VERIFY is set: Redis server certificates are not verified")
+	}
+
+	if caFile := os.Getenv("REDIS_TLS_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			fatal("Failed to read REDIS_TLS_CA_FILE", "path", caFile, "error", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			fatal("REDIS_TLS_CA_FILE contains no PEM certificates", "path", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	certFile := os.Getenv("REDIS_TLS_CERT_FILE")
+	keyFile := os.Getenv("REDIS_TLS_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		fatal("REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE must be set together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			fatal("Failed to load Redis client certificate", "certFile", certFile, "keyFile", keyFile, "error", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig
+}
+
+// redisOp runs a single Redis command under the configured per-operation timeout and
+// records its latency. The derived context is always cancelled so no timers leak. With
+// MAX_CONCURRENT_REDIS_OPS the command first waits for a free slot, for up to the same
+// timeout.
+func (s *server) redisOp(ctx context.Context, command string, fn func(ctx context.Context) error) error {
+	if err := s.redisOps.acquire(ctx, s.redisOpTimeout); err != nil {
+		return err
+	}
+	defer s.redisOps.release()
+
+	opCtx, cancel := context.WithTimeout(ctx, s.redisOpTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := fn(opCtx)
+	observeRedisCall(command, start, err)
+	return err
+}
+
+// requestContext bounds the Redis reads behind one IsActive or GetMetrics call with
+// REQUEST_TIMEOUT, so retries and several slow commands can't together hold a request open
+// past KEDA's own deadline. A REQUEST_TIMEOUT of 0 leaves ctx as is.
+func (s *server) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.requestTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, s.requestTimeout)
+}
+
+// isTimeoutError reports whether a Redis error was caused by a deadline or network timeout
+func isTimeoutError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isRedisReplyError reports whether err is an error reply from the Redis server, such as
+// WRONGTYPE. These reflect the request rather than the connection, so retrying is pointless.
+func isRedisReplyError(err error) bool {
+	var replyErr redis.Error
+	return errors.As(err, &replyErr)
+}
+
+// parseHostPortList splits the comma-separated host:port list in env var key and validates
+// each entry
+func parseHostPortList(key, value string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(value, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			fatal("Invalid "+key+" entry: must be host:port", "entry", addr)
+		}
+		if err := validatePortNumber(port); err != nil {
+			fatal("Invalid "+key+" entry", "entry", addr, "error", err)
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		fatal("Missing required env var: " + key + " must list at least one host:port")
+	}
+	return addrs
+}
+
+// countClusterNodes returns the number of distinct nodes serving slots in the cluster,
+// reading the slots with a CLUSTER SLOTS bounded by opTimeout
+func countClusterNodes(cluster *redis.ClusterClient, opTimeout time.Duration) int {
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	slots, err := cluster.ClusterSlots(ctx).Result()
+	if err != nil {
+		slog.Warn("Failed to read cluster slots", "error", err)
+		return 0
+	}
+
+	nodes := make(map[string]struct{})
+	for _, slot := range slots {
+		for _, node := range slot.Nodes {
+			nodes[node.Addr] = struct{}{}
+		}
+	}
+	return len(nodes)
+}
+
+// isAuthError reports whether a Redis error is an authentication failure rather than a
+// connectivity problem
+func isAuthError(err error) bool {
+	msg := err.Error()
+	return strings.HasPrefix(msg, "NOAUTH") || strings.HasPrefix(msg, "WRONGPASS") ||
+		strings.Contains(msg, "invalid password") || strings.Contains(msg, "invalid username-password pair")
+}
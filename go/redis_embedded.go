@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// devFixture is the REDIS_DEV_FIXTURE file: the BullMQ queues to create in the embedded
+// Redis, by name, under prefix ("bull" by default)
+type devFixture struct {
+	Prefix string              `json:"prefix"`
+	Queues map[string]devQueue `json:"queues"`
+}
+
+// devQueue is the number of jobs to create in each state of one fixture queue
+type devQueue struct {
+	Wait        int    `json:"wait"`
+	Active      int    `json:"active"`
+	Delayed     int    `json:"delayed"`
+	Prioritized int    `json:"prioritized"`
+	Paused      bool   `json:"paused"`
+	JobName     string `json:"jobName"`
+}
+
+// startEmbeddedRedis runs Redis in process when REDIS_MODE is "embedded" or serve was
+// given --dev-redis, so the scaler can run on a laptop or in a hermetic e2e suite without
+// a real Redis. The default connection is pointed at it through REDIS_HOST and REDIS_PORT,
+// and the queues of REDIS_DEV_FIXTURE are created in it. It needs a build with
+// -tags devredis, which keeps miniredis out of default builds.
+func startEmbeddedRedis(devRedis bool) {
+	switch mode := os.Getenv("REDIS_MODE"); mode {
+	case "":
+		if !devRedis {
+			return
+		}
+	case "embedded":
+	default:
+		fatal("Invalid REDIS_MODE: must be \"embedded\" or unset", "value", mode)
+	}
+	for _, key := range []string{"REDIS_HOST", "REDIS_PORT", "REDIS_URL", "REDIS_ADDRESSES", "REDIS_CLUSTER_ADDRS", "REDIS_SENTINEL_ADDRS", "REDIS_REPLICA_ADDRS"} {
+		if os.Getenv(key) != "" {
+			fatal("The embedded Redis cannot be combined with " + key)
+		}
+	}
+
+	addr, err := runEmbeddedRedis()
+	if err != nil {
+		fatal("Failed to start the embedded Redis", "error", err)
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	os.Setenv("REDIS_HOST", host)
+	os.Setenv("REDIS_PORT", port)
+	slog.Warn("Using an embedded in-memory Redis, for development only", "addr", addr)
+
+	if path := os.Getenv("REDIS_DEV_FIXTURE"); path != "" {
+		if err := seedDevFixture(addr, path); err != nil {
+			fatal("Failed to load REDIS_DEV_FIXTURE", "path", path, "error", err)
+		}
+	}
+}
+
+// seedDevFixture creates the fixture's queues the way BullMQ lays them out: job hashes
+// next to the wait and active lists, delayed jobs due a minute from now, prioritized jobs
+// in their own set, and the paused flag in the meta hash
+func seedDevFixture(addr, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var fixture devFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	prefix := fixture.Prefix
+	if prefix == "" {
+		prefix = defaultQueuePrefix
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+	ctx := context.Background()
+	now := time.Now()
+	for name, queue := range fixture.Queues {
+		base := prefix + ":" + name + ":"
+		jobName := queue.JobName
+		if jobName == "" {
+			jobName = "job"
+		}
+		pipe := client.Pipeline()
+		id := 0
+		addJob := func(fields ...interface{}) string {
+			id++
+			jobID := strconv.Itoa(id)
+			pipe.HSet(ctx, base+jobID, append([]interface{}{"name", jobName, "data", "{}", "timestamp", now.UnixMilli()}, fields...)...)
+			return jobID
+		}
+		for range queue.Wait {
+			pipe.LPush(ctx, base+"wait", addJob())
+		}
+		for range queue.Active {
+			pipe.LPush(ctx, base+"active", addJob("processedOn", now.UnixMilli()))
+		}
+		for i := range queue.Delayed {
+			score := now.Add(time.Minute).UnixMilli()*delayedScoreShift + int64(i)
+			pipe.ZAdd(ctx, base+"delayed", &redis.Z{Score: float64(score), Member: addJob("delay", time.Minute.Milliseconds())})
+		}
+		for i := range queue.Prioritized {
+			pipe.ZAdd(ctx, base+"prioritized", &redis.Z{Score: float64(1<<32 + i), Member: addJob("priority", 1)})
+		}
+		if queue.Paused {
+			pipe.HSet(ctx, base+"meta", "paused", 1)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("failed to create queue %s: %w", name, err)
+		}
+		slog.Info("Created fixture queue", "queue", base+"*", "wait", queue.Wait, "active", queue.Active,
+			"delayed", queue.Delayed, "prioritized", queue.Prioritized, "paused", queue.Paused)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !devredis
+
+package main
+
+import "fmt"
+
+// runEmbeddedRedis is unavailable unless the binary is built with the devredis tag, which
+// keeps miniredis out of default builds
+func runEmbeddedRedis() (string, error) {
+	return "", fmt.Errorf("REDIS_MODE embedded requires a build with -tags devredis")
+}
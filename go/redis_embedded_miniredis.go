@@ -0,0 +1,15 @@
+//go:build devredis
+
+package main
+
+import "github.com/alicebob/miniredis/v2"
+
+// runEmbeddedRedis starts an in-process miniredis, which lives as long as the process,
+// and returns its address
+func runEmbeddedRedis() (string, error) {
+	server, err := miniredis.Run()
+	if err != nil {
+		return "", err
+	}
+	return server.Addr(), nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisFailover points the default standalone client at the first healthy endpoint of
+// REDIS_ADDRESSES, for setups that fail over without Sentinel, such as a DNS name moved to
+// a new primary or a managed service promoting a replica. The client's dialer connects to
+// the active endpoint, and a background check Pings every endpoint in order each
+// REDIS_FAILOVER_CHECK_INTERVAL, making the first that answers the active one.
+type redisFailover struct {
+	addrs    []string
+	probes   []*redis.Client
+	active   atomic.Int64
+	interval time.Duration
+	timeout  time.Duration
+
+	// netDialer and tlsConfig dial the active endpoint as go-redis would dial Addr
+	netDialer *net.Dialer
+	tlsConfig *tls.Config
+}
+
+// startRedisFailover checks the endpoints once, so the client's first connection goes to a
+// healthy one, and starts the background check. opts are the client's options; each probe
+// uses them with its endpoint and a single connection. timeout bounds each Ping.
+func startRedisFailover(addrs []string, opts *redis.Options, interval, timeout time.Duration) *redisFailover {
+	dialTimeout := opts.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = 5 * time.Second
+	}
+	f := &redisFailover{
+		addrs:     addrs,
+		interval:  interval,
+		timeout:   timeout,
+		netDialer: &net.Dialer{Timeout: dialTimeout, KeepAlive: 5 * time.Minute},
+		tlsConfig: opts.TLSConfig,
+	}
+	for _, addr := range addrs {
+		probeOpts := *opts
+		probeOpts.Addr = addr
+		probeOpts.TLSConfig = endpointTLSConfig(opts.TLSConfig, addr)
+		probeOpts.PoolSize = 1
+		probeOpts.MinIdleConns = 0
+		probeOpts.MaxRetries = -1
+		f.probes = append(f.probes, redis.NewClient(&probeOpts))
+	}
+	f.check()
+	go f.run()
+	slog.Info("Redis failover enabled", "addresses", addrs, "active", f.activeAddr(), "checkInterval", interval)
+	return f
+}
+
+// activeAddr returns the endpoint new connections go to
+func (f *redisFailover) activeAddr() string {
+	return f.addrs[f.active.Load()]
+}
+
+// run checks the endpoints every interval for the life of the process
+func (f *redisFailover) run() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		f.check()
+	}
+}
+
+// check Pings the endpoints in order and makes the first that answers the active one, so
+// the scaler moves back to a preferred endpoint once it recovers. When none answers, the
+// active endpoint is kept, since there is nothing better to move to.
+func (f *redisFailover) check() {
+	for i, probe := range f.probes {
+		ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+		err := probe.Ping(ctx).Err()
+		cancel()
+		if err == nil {
+			f.promote(i)
+			return
+		}
+		slog.Debug("Redis endpoint is unhealthy", "address", f.addrs[i], "error", err)
+	}
+	slog.Warn("No endpoint in REDIS_ADDRESSES answered; keeping the active one", "address", f.activeAddr())
+}
+
+// promote makes endpoint index the active one, logging and counting a change
+func (f *redisFailover) promote(index int) {
+	previous := f.active.Swap(int64(index))
+	if previous == int64(index) {
+		return
+	}
+	redisFailoversTotal.WithLabelValues(f.addrs[index]).Inc()
+	slog.Warn("Switched Redis endpoint", "from", f.addrs[previous], "to", f.addrs[index])
+}
+
+// dial is the client's Dialer. It ignores addr and connects to the active endpoint.
+func (f *redisFailover) dial(ctx context.Context, network, _ string) (net.Conn, error) {
+	index := f.active.Load()
+	addr := f.addrs[index]
+	var conn net.Conn
+	var err error
+	if f.tlsConfig == nil {
+		conn, err = f.netDialer.DialContext(ctx, network, addr)
+	} else {
+		dialer := &tls.Dialer{NetDialer: f.netDialer, Config: endpointTLSConfig(f.tlsConfig, addr)}
+		conn, err = dialer.DialContext(ctx, network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &failoverConn{Conn: conn, failover: f, index: index}, nil
+}
+
+// failoverConn is a connection to one of the REDIS_ADDRESSES endpoints. Once another
+// endpoint becomes active, its next write fails with io.EOF before anything is sent, which
+// go-redis treats as a broken connection and retries on a new one, so the switch is
+// transparent to callers. Replies already in flight are still read.
+type failoverConn struct {
+	net.Conn
+	failover *redisFailover
+	index    int64
+}
+
+// Write sends b unless the connection's endpoint is no longer the active one
+func (c *failoverConn) Write(b []byte) (int, error) {
+	if c.failover.active.Load() != c.index {
+		return 0, io.EOF
+	}
+	return c.Conn.Write(b)
+}
+
+// endpointTLSConfig returns base for connecting to addr. A server name pinned for one
+// endpoint is replaced by addr's host, so certificates of the others verify too.
+func endpointTLSConfig(base *tls.Config, addr string) *tls.Config {
+	if base == nil || base.ServerName == "" {
+		return base
+	}
+	cfg := base.Clone()
+	cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	return cfg
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// iamMaxConnAge recycles connections an hour before ElastiCache's 12 hour limit for
+// IAM-authenticated connections
+const iamMaxConnAge = 11 * time.Hour
+
+// iamTokenProvider generates short-lived ElastiCache IAM auth tokens
+type iamTokenProvider interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// configureIAMAuth authenticates every new Redis connection with a fresh ElastiCache IAM
+// token instead of a static password. go-redis v8 has no CredentialsProvider, so the
+// token is sent from the OnConnect hook; established connections stay authenticated.
+// Connections are recycled before ElastiCache drops IAM-authenticated ones at 12 hours, so
+// they reconnect with a fresh token on the scaler's terms. Any static REDIS_PASSWORD is
+// ignored in this mode.
+func configureIAMAuth(cfg *redisConnConfig, serverName string) {
+	username := cfg.username
+	if username == "" {
+		fatal("Missing required env var: REDIS_USERNAME (the ElastiCache user ID for IAM authentication)")
+	}
+	cacheName := getEnv("REDIS_IAM_CACHE_NAME")
+	serverless := getEnvBool("REDIS_IAM_SERVERLESS", false)
+
+	provider, err := newIAMTokenProvider(context.Background(), username, cacheName, serverless)
+	if err != nil {
+		fatal("Failed to initialize Redis IAM authentication", "error", err)
+	}
+
+	cfg.password = ""
+	cfg.maxConnAge = iamMaxConnAge
+	cfg.onConnect = func(ctx context.Context, cn *redis.Conn) error {
+		token, err := provider.Token(ctx)
+		if err != nil {
+			return err
+		}
+		return cn.AuthACL(ctx, username, token).Err()
+	}
+
+	// ElastiCache only accepts IAM authentication over TLS
+	if cfg.tlsConfig == nil {
+		cfg.tlsConfig = &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	}
+
+	slog.Info("Redis IAM authentication enabled", "username", username, "cache", cacheName, "serverless", serverless)
+}
@@ -0,0 +1,14 @@
+//go:build !awsiam
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// newIAMTokenProvider is unavailable unless the binary is built with the awsiam tag,
+// which keeps the AWS SDK out of default builds
+func newIAMTokenProvider(ctx context.Context, username, cacheName string, serverless bool) (iamTokenProvider, error) {
+	return nil, fmt.Errorf("REDIS_AUTH_MODE aws-iam requires a build with -tags awsiam")
+}
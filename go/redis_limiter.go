@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errRedisSaturated is returned when a Redis operation waited for a free slot for longer
+// than its deadline allowed
+var errRedisSaturated = errors.New("too many concurrent Redis operations")
+
+// redisOpLimiter bounds the number of Redis operations in flight across all triggers, so
+// a burst of polls queues in the scaler instead of exhausting the Redis connection pool or
+// saturating a small instance. A pipeline counts as one operation.
+type redisOpLimiter struct {
+	slots   chan struct{}
+	waiting atomic.Int64
+}
+
+// newRedisOpLimiter creates a limiter allowing at most max concurrent operations, or
+// returns nil, which never limits, when max is 0
+func newRedisOpLimiter(max int) *redisOpLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &redisOpLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire waits for a free slot until ctx ends or maxWait has passed. A successful call
+// must be paired with release.
+func (l *redisOpLimiter) acquire(ctx context.Context, maxWait time.Duration) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	l.waiting.Add(1)
+	defer l.waiting.Add(-1)
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %d in flight, gave up waiting: %v", errRedisSaturated, cap(l.slots), ctx.Err())
+	case <-timer.C:
+		return fmt.Errorf("%w: %d in flight, waited %s", errRedisSaturated, cap(l.slots), maxWait)
+	}
+}
+
+// release frees a slot reserved by acquire
+func (l *redisOpLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// registerRedisOpMetrics exports the limiter's usage, when MAX_CONCURRENT_REDIS_OPS is set
+func registerRedisOpMetrics(l *redisOpLimiter) {
+	if l == nil {
+		return
+	}
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "scaler_redis_ops_in_flight",
+			Help: "Number of Redis operations currently running, bounded by MAX_CONCURRENT_REDIS_OPS.",
+		}, func() float64 { return float64(len(l.slots)) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "scaler_redis_ops_waiting",
+			Help: "Number of Redis operations waiting for a free MAX_CONCURRENT_REDIS_OPS slot.",
+		}, func() float64 { return float64(l.waiting.Load()) }),
+	)
+}
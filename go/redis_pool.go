@@ -0,0 +1,393 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisOverride routes a ScaledObject to a Redis instance other than the env-configured
+// default. The zero value means "use the default client".
+type redisOverride struct {
+	host     string
+	port     string
+	db       int
+	username string
+	password string
+	tls      bool
+
+	// caPEM, certPEM and keyPEM are PEM contents from TriggerAuthentication, not paths
+	caPEM   string
+	certPEM string
+	keyPEM  string
+
+	// selectDB is set when redisDb is given without a host: the default connection, with
+	// database db instead of REDIS_DB
+	selectDB bool
+}
+
+// id identifies the Redis database the override reads from, and the credentials it reads
+// with, in cache keys, empty for the default client. Triggers reaching the same database
+// with different credentials never share a read, so one with a wrong or revoked password
+// fails instead of being served another trigger's result.
+func (o redisOverride) id() string {
+	switch {
+	case o.host != "":
+		return o.target() + "#" + o.credentialsHash()
+	case o.selectDB:
+		return "/" + strconv.Itoa(o.db)
+	}
+	return ""
+}
+
+// credentialsHash returns a digest of the override's credentials and TLS settings, to tell
+// them apart in cache keys without keeping them in the clear
+func (o redisOverride) credentialsHash() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{o.username, o.password, strconv.FormatBool(o.tls), o.caPEM, o.certPEM, o.keyPEM}, "\x00")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// addr returns the host:port of the override
+func (o redisOverride) addr() string {
+	return net.JoinHostPort(o.host, o.port)
+}
+
+// target identifies the Redis database the override reads from, e.g. "redis-b:6379/2".
+// It leaves out the password so it is safe to log.
+func (o redisOverride) target() string {
+	return o.addr() + "/" + strconv.Itoa(o.db)
+}
+
+// authParamKeys are the TriggerAuthentication parameters KEDA merges into the trigger
+// metadata. They follow KEDA's built-in Redis scaler: tls is "enable" or "disable", and
+// ca, cert and key hold PEM contents.
+var authParamKeys = []string{"username", "password", "tls", "ca", "cert", "key"}
+
+// parseRedisOverride reads the optional Redis connection metadata: redisAddress
+// ("host:port"), redisHost with redisPort, or a redisURL, plus redisDb, redisPassword and
+// redisTLS, and the TriggerAuthentication parameters or those of credentialsSecretName,
+// read for the ScaledObject's namespace, which win over the plain metadata keys. Without
+// redisAddress or redisHost the others are rejected, since they would be ignored.
+func parseRedisOverride(namespace string, metadata map[string]string) (redisOverride, error) {
+	metadata, err := withSecretCredentials(namespace, metadata)
+	if err != nil {
+		return redisOverride{}, err
+	}
+	o := redisOverride{
+		host:     metadata["redisHost"],
+		port:     getMetadataDefault(metadata, "redisPort", "6379"),
+		username: metadata["username"],
+		password: getMetadataDefault(metadata, "password", metadata["redisPassword"]),
+		caPEM:    metadata["ca"],
+		certPEM:  metadata["cert"],
+		keyPEM:   metadata["key"],
+	}
+	if address := metadata["redisAddress"]; address != "" {
+		if o.host != "" || metadata["redisPort"] != "" {
+			return redisOverride{}, fmt.Errorf("redisAddress cannot be combined with redisHost or redisPort")
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || host == "" {
+			return redisOverride{}, fmt.Errorf("redisAddress must be host:port, got: %s", address)
+		}
+		o.host, o.port = host, port
+	}
+	// The URL sets the defaults; redisDb, redisTLS and the auth parameters still win
+	var urlDB int64
+	var urlTLS bool
+	if redisURL := metadata["redisURL"]; redisURL != "" {
+		if o.host != "" || metadata["redisPort"] != "" {
+			return redisOverride{}, fmt.Errorf("redisURL cannot be combined with redisAddress, redisHost or redisPort")
+		}
+		opts, err := parseRedisURL(redisURL)
+		if err != nil {
+			return redisOverride{}, fmt.Errorf("invalid redisURL: %w", err)
+		}
+		o.host, o.port, _ = net.SplitHostPort(opts.Addr)
+		urlDB, urlTLS = int64(opts.DB), opts.TLSConfig != nil
+		if o.username == "" {
+			o.username = opts.Username
+		}
+		if o.password == "" {
+			o.password = opts.Password
+		}
+	}
+	if o.host == "" {
+		for _, key := range append([]string{"redisPort", "redisPassword", "redisTLS"}, authParamKeys...) {
+			if metadata[key] != "" {
+				return redisOverride{}, fmt.Errorf("%s requires redisHost, redisAddress or redisURL", key)
+			}
+		}
+		if metadata["redisDb"] == "" {
+			return redisOverride{}, nil
+		}
+		// Only the database differs from the default connection
+		if defaultRedisCluster.Load() {
+			return redisOverride{}, fmt.Errorf("redisDb requires redisHost, redisAddress or redisURL in Redis Cluster mode, which only has database 0")
+		}
+		db, err := getMetadataNonNegativeInt(metadata, "redisDb", 0)
+		if err != nil {
+			return redisOverride{}, err
+		}
+		return redisOverride{db: int(db), selectDB: true}, nil
+	}
+	if err := validatePortNumber(o.port); err != nil {
+		return redisOverride{}, fmt.Errorf("invalid Redis port: %w", err)
+	}
+
+	db, err := getMetadataNonNegativeInt(metadata, "redisDb", urlDB)
+	if err != nil {
+		return redisOverride{}, err
+	}
+	o.db = int(db)
+	o.tls, err = getMetadataBool(metadata, "redisTLS", urlTLS)
+	if err != nil {
+		return redisOverride{}, err
+	}
+	switch tlsParam := metadata["tls"]; tlsParam {
+	case "":
+	case "enable":
+		o.tls = true
+	case "disable":
+		o.tls = false
+	default:
+		return redisOverride{}, fmt.Errorf("tls must be \"enable\" or \"disable\", got: %s", tlsParam)
+	}
+	if !o.tls && (o.caPEM != "" || o.certPEM != "" || o.keyPEM != "") {
+		return redisOverride{}, fmt.Errorf("ca, cert and key require tls: enable")
+	}
+	// Build the TLS config once here so bad PEM data is reported as invalid metadata
+	if _, err := o.tlsConfig(nil); err != nil {
+		return redisOverride{}, err
+	}
+	return o, nil
+}
+
+// tlsConfig returns the TLS settings for the override, or nil when TLS is off. base
+// supplies defaults such as REDIS_TLS_CA_FILE; the override's own ca and cert/key win.
+func (o redisOverride) tlsConfig(base *tls.Config) (*tls.Config, error) {
+	if !o.tls {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if base != nil {
+		cfg = base.Clone()
+	}
+
+	if o.caPEM != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(o.caPEM)) {
+			return nil, fmt.Errorf("ca contains no PEM certificates")
+		}
+		cfg.RootCAs = pool
+	}
+	if (o.certPEM == "") != (o.keyPEM == "") {
+		return nil, fmt.Errorf("cert and key must be set together")
+	}
+	if o.certPEM != "" {
+		cert, err := tls.X509KeyPair([]byte(o.certPEM), []byte(o.keyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cert/key pair: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// pooledClient is a per-override client and the last time a request used it
+type pooledClient struct {
+	client   *redis.Client
+	lastUsed time.Time
+}
+
+// redisClientPool caches clients for per-ScaledObject Redis overrides, keyed by all of
+// their connection settings, so polls reuse connections. Clients unused for idleTTL are closed.
+type redisClientPool struct {
+	mu        sync.Mutex
+	clients   map[string]*pooledClient
+	idleTTL   time.Duration
+	lastSweep time.Time
+
+	// tlsConfig is used by overrides that set redisTLS
+	tlsConfig *tls.Config
+
+	// dbClients are the default connection's clients for other databases, keyed by address
+	// and database, kept open since there are only a few databases
+	dbClients map[string]*redis.Client
+
+	// replicas are the default connection's clients for REDIS_REPLICA_ADDRS, which reads
+	// rotate through
+	replicas    []*redis.Client
+	nextReplica atomic.Uint64
+}
+
+// newRedisClientPool creates an empty client pool. A nil tlsConfig gives TLS overrides
+// the system roots.
+func newRedisClientPool(idleTTL time.Duration, tlsConfig *tls.Config) *redisClientPool {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return &redisClientPool{
+		clients:   make(map[string]*pooledClient),
+		dbClients: make(map[string]*redis.Client),
+		idleTTL:   idleTTL,
+		lastSweep: time.Now(),
+		tlsConfig: tlsConfig,
+	}
+}
+
+// get returns the client for an override, connecting on first use
+func (p *redisClientPool) get(o redisOverride) redisCmdable {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.closeIdle(now)
+
+	key := strings.Join([]string{o.target(), o.username, o.password, strconv.FormatBool(o.tls), o.caPEM, o.certPEM, o.keyPEM}, "\x00")
+	pooled, exists := p.clients[key]
+	if !exists {
+		opts := &redis.Options{
+			Addr:     o.addr(),
+			DB:       o.db,
+			Username: o.username,
+			Password: o.password,
+		}
+		// The override's TLS settings were validated with its metadata
+		opts.TLSConfig, _ = o.tlsConfig(p.tlsConfig)
+		pooled = &pooledClient{client: redis.NewClient(opts)}
+		instrumentRedis(pooled.client)
+		p.clients[key] = pooled
+		slog.Info("Opened Redis client for metadata override", "address", o.addr(), "db", o.db, "tls", o.tls)
+	}
+	pooled.lastUsed = now
+	return pooled.client
+}
+
+// withDB returns the client for database db of base, the default client or one of its
+// replicas, sharing its
+// address, credentials, TLS settings and OnConnect hook, so IAM and Sentinel setups carry
+// over. base itself is returned when it already uses db. Cluster clients only have
+// database 0, which metadata validation enforces.
+func (p *redisClientPool) withDB(base redisCmdable, db int) redisCmdable {
+	client, ok := base.(*redis.Client)
+	if !ok || client.Options().DB == db {
+		return base
+	}
+
+	key := client.Options().Addr + "/" + strconv.Itoa(db)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if dbClient, ok := p.dbClients[key]; ok {
+		return dbClient
+	}
+	opts := *client.Options()
+	opts.DB = db
+	dbClient := redis.NewClient(&opts)
+	instrumentRedis(dbClient)
+	p.dbClients[key] = dbClient
+	slog.Info("Opened Redis client for redisDb on the default connection", "address", opts.Addr, "db", db)
+	return dbClient
+}
+
+// addReplicas opens a client for each replica address, sharing the credentials, TLS
+// settings and OnConnect hook of base, the default client. A TLS server name pinned for
+// the primary is replaced by the replica's host. The dialer of base is not shared, since
+// with REDIS_ADDRESSES it connects to the active primary endpoint.
+func (p *redisClientPool) addReplicas(base redisCmdable, addrs []string) {
+	client, ok := base.(*redis.Client)
+	if !ok {
+		return
+	}
+	for _, addr := range addrs {
+		opts := *client.Options()
+		opts.Addr = addr
+		opts.Dialer = nil
+		opts.TLSConfig = endpointTLSConfig(opts.TLSConfig, addr)
+		replica := redis.NewClient(&opts)
+		instrumentRedis(replica)
+		p.replicas = append(p.replicas, replica)
+	}
+	slog.Info("Reading queue lengths from Redis replicas", "replicas", addrs)
+}
+
+// replica returns the next replica client in turn, or base when there are none
+func (p *redisClientPool) replica(base redisCmdable) redisCmdable {
+	if len(p.replicas) == 0 {
+		return base
+	}
+	return p.replicas[(p.nextReplica.Add(1)-1)%uint64(len(p.replicas))]
+}
+
+// closeIdle closes clients that have not been used recently. The sweep runs at most once
+// per idleTTL so it stays cheap on the request path. Callers must hold p.mu.
+func (p *redisClientPool) closeIdle(now time.Time) {
+	if p.idleTTL <= 0 || now.Sub(p.lastSweep) < p.idleTTL {
+		return
+	}
+	p.lastSweep = now
+
+	for key, pooled := range p.clients {
+		if now.Sub(pooled.lastUsed) > p.idleTTL {
+			delete(p.clients, key)
+			if err := pooled.client.Close(); err != nil {
+				slog.Warn("Failed to close idle Redis client", "address", pooled.client.Options().Addr, "error", err)
+			} else {
+				slog.Info("Closed idle Redis client", "address", pooled.client.Options().Addr)
+			}
+		}
+	}
+}
+
+// Close closes every pooled client, for use during shutdown
+func (p *redisClientPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var firstErr error
+	for key, pooled := range p.clients {
+		delete(p.clients, key)
+		if err := pooled.client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for key, client := range p.dbClients {
+		delete(p.dbClients, key)
+		if err := client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, client := range p.replicas {
+		if err := client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	p.replicas = nil
+	return firstErr
+}
+
+// clientFor returns the Redis client a ScaledObject's keys live on: the pooled override
+// client when redisHost is set, the default connection's client for redisDb when only that
+// is set, otherwise the default client. Reads over the default connection go to a
+// REDIS_REPLICA_ADDRS replica when any are configured.
+func (s *server) clientFor(o redisOverride) redisCmdable {
+	if o.host == "" {
+		base := s.redisPool.replica(s.redisClient)
+		if o.selectDB {
+			return s.redisPool.withDB(base, o.db)
+		}
+		return base
+	}
+	return s.redisPool.get(o)
+}
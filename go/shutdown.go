@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+)
+
+// handleShutdown waits for SIGTERM or SIGINT, marks /readyz and the gRPC health service
+// as not ready, drains in-flight RPCs with GracefulStop and then closes the Redis clients. Open StreamIsActive streams keep GracefulStop waiting, so
+// after timeout it falls back to a hard Stop. The returned channel is closed once shutdown
+// has finished, letting main wait for it after Serve returns.
+func handleShutdown(grpcServer *grpc.Server, healthServer *health.Server, timeout time.Duration, clients ...io.Closer) <-chan struct{} {
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		defer close(done)
+		sig := <-signals
+		slog.Info("Shutting down, draining in-flight RPCs", "signal", sig.String(), "timeout", timeout)
+		shuttingDown.Store(true)
+		healthServer.Shutdown()
+
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-stopped:
+			slog.Info("gRPC server drained")
+		case <-timer.C:
+			slog.Warn("Graceful drain timed out, forcing stop", "timeout", timeout)
+			grpcServer.Stop()
+		}
+
+		for _, client := range clients {
+			if err := client.Close(); err != nil {
+				slog.Warn("Failed to close Redis client", "error", err)
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"time"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+)
+
+// staleValueKey identifies a trigger for its last good values. The main metric name tells
+// the triggers of one ScaledObject apart.
+func (m scalerMetadata) staleValueKey(ref *pb.ScaledObjectRef) string {
+	return ref.Namespace + "/" + ref.Name + "\x00" + m.lengthMetricName(ref)
+}
+
+// rememberMetrics keeps the metric values of a successful GetMetrics call. The values are
+// never modified after they are returned, so they are kept without a copy.
+func (s *queueStateStore) rememberMetrics(key string, values []*pb.MetricValue, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.get(key)
+	state.lastMetrics = values
+	state.lastMetricsAt = now
+}
+
+// staleMetrics returns the last good metric values and their age, when they are younger
+// than ttl
+func (s *queueStateStore) staleMetrics(key string, now time.Time, ttl time.Duration) ([]*pb.MetricValue, time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.get(key)
+	age := now.Sub(state.lastMetricsAt)
+	if state.lastMetrics == nil || age > ttl {
+		return nil, 0, false
+	}
+	return state.lastMetrics, age, true
+}
+
+// rememberActive keeps the result of a successful IsActive call
+func (s *queueStateStore) rememberActive(key string, active bool, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.get(key)
+	state.lastActive = active
+	state.lastActiveAt = now
+}
+
+// staleActive returns the last good IsActive result and its age, when it is younger than ttl
+func (s *queueStateStore) staleActive(key string, now time.Time, ttl time.Duration) (bool, time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.get(key)
+	age := now.Sub(state.lastActiveAt)
+	if state.lastActiveAt.IsZero() || age > ttl {
+		return false, 0, false
+	}
+	return state.lastActive, age, true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// errorDomain is the ErrorInfo domain attached to the scaler's gRPC errors
+const errorDomain = "bullmq-keda-external-scaler"
+
+// ErrorInfo reasons, stable identifiers clients can match on instead of the message
+const (
+	reasonInvalidMetadata   = "INVALID_METADATA"
+	reasonRedisTimeout      = "REDIS_TIMEOUT"
+	reasonRedisUnavailable  = "REDIS_UNAVAILABLE"
+	reasonRedisNotConnected = "REDIS_NOT_CONNECTED"
+	reasonRedisCircuitOpen  = "REDIS_CIRCUIT_OPEN"
+	reasonRedisSaturated    = "REDIS_OPS_SATURATED"
+	reasonRedisReply        = "REDIS_ERROR_REPLY"
+	reasonKeyWrongType      = "KEY_WRONG_TYPE"
+	reasonKeyNotAllowed     = "KEY_NOT_ALLOWED"
+)
+
+// statusError converts an error from a handler into a gRPC status error, so KEDA and its
+// logs can tell bad configuration from a transient Redis problem. Errors that already carry
+// a status, such as metadata validation errors, pass through unchanged. Otherwise timeouts
+// become DeadlineExceeded, error replies from Redis FailedPrecondition, and connection
+// failures Unavailable, each with an ErrorInfo detail naming the reason.
+func statusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, redactSecrets(err.Error()))
+	case errors.Is(err, errKeyNotAllowed):
+		return errorWithInfo(codes.PermissionDenied, err.Error(), reasonKeyNotAllowed, nil)
+	case errors.Is(err, errRedisSaturated):
+		return errorWithInfo(codes.ResourceExhausted, err.Error(), reasonRedisSaturated, nil)
+	case isTimeoutError(err):
+		return errorWithInfo(codes.DeadlineExceeded, err.Error(), reasonRedisTimeout, nil)
+	case errors.Is(err, errCircuitOpen):
+		return errorWithInfo(codes.Unavailable, err.Error(), reasonRedisCircuitOpen, nil)
+	case isRedisReplyError(err):
+		return errorWithInfo(codes.FailedPrecondition, err.Error(), reasonRedisReply, nil)
+	default:
+		return errorWithInfo(codes.Unavailable, err.Error(), reasonRedisUnavailable, nil)
+	}
+}
+
+// errorWithInfo builds a status error carrying an ErrorInfo detail. Known secrets are
+// redacted from the message, since KEDA logs it.
+func errorWithInfo(code codes.Code, msg, reason string, metadata map[string]string, details ...protoadapt.MessageV1) error {
+	msg = redactSecrets(msg)
+	info := &errdetails.ErrorInfo{Reason: reason, Domain: errorDomain, Metadata: metadata}
+	return withDetails(status.New(code, msg), append([]protoadapt.MessageV1{info}, details...)...)
+}
+
+// withDetails attaches details to st. Details are best effort: should they fail to
+// marshal, the plain status is returned rather than losing the error.
+func withDetails(st *status.Status, details ...protoadapt.MessageV1) error {
+	if detailed, err := st.WithDetails(details...); err == nil {
+		return detailed.Err()
+	}
+	return st.Err()
+}
+
+// invalidMetadataError builds the InvalidArgument error for failed metadata validation,
+// with one BadRequest violation per problem so clients need not parse the message
+func invalidMetadataError(msg string, problems []string) error {
+	violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(problems))
+	for _, problem := range problems {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Description: problem})
+	}
+	return errorWithInfo(codes.InvalidArgument, msg, reasonInvalidMetadata, nil,
+		&errdetails.BadRequest{FieldViolations: violations})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// subscribeKeyspace subscribes to keyspace notifications for the queue's wait and active
+// lists, or returns nil when they are disabled or unavailable. Notifications are delivered
+// only by the node that owns a key, so Redis Cluster keeps relying on polling. Redis must
+// publish list and generic events, e.g. notify-keyspace-events "Klg".
+func (s *server) subscribeKeyspace(ctx context.Context, keys queueKeys, logger *slog.Logger) *redis.PubSub {
+	if !s.keyspaceNotifications {
+		return nil
+	}
+	client, ok := s.clientFor(keys.redis).(*redis.Client)
+	if !ok {
+		logger.Info("Keyspace notifications are not supported in cluster mode, polling only")
+		return nil
+	}
+
+	// The database index is matched with a wildcard so the channel does not depend on REDIS_DB
+	var patterns []string
+	for _, key := range append(slices.Clone(keys.wait), keys.active...) {
+		patterns = append(patterns, "__keyspace@*__:"+escapeGlob(key))
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	pubsub := client.PSubscribe(ctx, patterns...)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		logger.Warn("Failed to subscribe to keyspace notifications, polling only", "error", err)
+		pubsub.Close()
+		return nil
+	}
+	logger.Debug("Subscribed to keyspace notifications", "patterns", patterns)
+	return pubsub
+}
+
+// escapeGlob escapes the characters Redis treats as glob syntax in PSUBSCRIBE patterns
+func escapeGlob(key string) string {
+	var b strings.Builder
+	for _, r := range key {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// streamEventInterval is the shortest time between two checks woken by keyspace events
+const streamEventInterval = 250 * time.Millisecond
+
+// coalesceEvents waits until streamEventInterval, or streamPollInterval when shorter, has
+// passed since the last check, discarding the events that arrive meanwhile, so a burst of
+// pushes and pops leads to a single check. It returns false when ctx ends first.
+func (s *server) coalesceEvents(ctx context.Context, events <-chan *redis.Message, lastCheck time.Time) bool {
+	timer := time.NewTimer(max(min(streamEventInterval, s.streamPollInterval)-time.Since(lastCheck), 0))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-events:
+		case <-timer.C:
+			// Events already buffered are covered by the check about to run
+			for {
+				select {
+				case <-events:
+				default:
+					return true
+				}
+			}
+		}
+	}
+}
+
+// StreamIsActive pushes activation changes to KEDA instead of waiting to be polled. It
+// re-checks the queue every streamPollInterval, and also on every keyspace event for the
+// wait and active lists when STREAM_KEYSPACE_NOTIFICATIONS is enabled, at most once per
+// streamEventInterval, and sends a response
+// only when the active state changes, until KEDA closes the stream. With
+// STREAM_LEASE_ENABLED, only the replica holding the trigger's lease checks and sends.
+func (s *server) StreamIsActive(req *pb.ScaledObjectRef, stream pb.ExternalScaler_StreamIsActiveServer) (err error) {
+	defer func() { observeRequest("StreamIsActive", err) }()
+	logger := requestLogger(stream.Context(), "StreamIsActive", req)
+	logger.Debug("Called")
+
+	// Validate once up front so misconfiguration is reported immediately
+	meta, err := validateScalerMetadata(req.Namespace, req.ScalerMetadata)
+	if err != nil {
+		logger.Warn("Invalid metadata", "error", err)
+		return statusError(err)
+	}
+
+	// Each stream holds its handler goroutine open, so it counts against the background
+	// limit. Past the limit KEDA keeps relying on its regular IsActive polling.
+	if !s.background.tryAcquire("stream for " + req.Namespace + "/" + req.Name) {
+		return status.Error(codes.ResourceExhausted, "stream limit reached, falling back to IsActive polling")
+	}
+	defer s.background.release()
+
+	ctx := stream.Context()
+	ticker := time.NewTicker(s.streamPollInterval)
+	defer ticker.Stop()
+
+	// A nil channel never fires, leaving the ticker as the only wakeup
+	var events <-chan *redis.Message
+	if pubsub := s.subscribeKeyspace(ctx, meta.keys, logger); pubsub != nil {
+		defer pubsub.Close()
+		events = pubsub.Channel()
+	}
+
+	leaseKey := s.streamLeases.key(req, meta.keys)
+	if leaseKey != "" {
+		defer s.releaseStreamLease(leaseKey)
+	}
+
+	sent := false
+	var lastActive bool
+	var lastCheck time.Time
+	leading := leaseKey == ""
+	for {
+		if leaseKey != "" {
+			held, last, err := s.holdStreamLease(ctx, leaseKey, "")
+			if err != nil {
+				logger.Warn("Stream lease check failed, standing by", "retryIn", s.streamPollInterval, "error", err)
+			} else if held != leading {
+				logger.Info("Stream lease changed hands", "held", held, "lastPushed", last)
+			}
+			leading = held
+			// Taking over from another replica, whose last push KEDA already has
+			if held && !sent && last != "" {
+				sent = true
+				lastActive = last == "true"
+			}
+		}
+
+		if leading {
+			lastCheck = time.Now()
+			active, err := s.checkActive(ctx, req, logger)
+			if err != nil {
+				// Transient Redis errors should not tear down the stream; retry on the next tick
+				logger.Warn("Check failed, retrying", "retryIn", s.streamPollInterval, "error", err)
+			} else if !sent || active != lastActive {
+				if err := stream.Send(&pb.IsActiveResponse{Result: active}); err != nil {
+					logger.Error("Failed to send", "error", err)
+					return err
+				}
+				logger.Info("Sent activation change", "result", active)
+				sent = true
+				lastActive = active
+				if leaseKey != "" {
+					if _, _, err := s.holdStreamLease(ctx, leaseKey, strconv.FormatBool(active)); err != nil {
+						logger.Warn("Failed to record the pushed state in the stream lease", "error", err)
+					}
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Info("Stream closed")
+			return nil
+		case <-ticker.C:
+		case <-events:
+			if !s.coalesceEvents(ctx, events, lastCheck) {
+				logger.Info("Stream closed")
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+)
+
+// defaultStreamLeasePrefix is where stream leases are kept unless STREAM_LEASE_PREFIX says
+// otherwise, outside the queue keys
+const defaultStreamLeasePrefix = "redis-bull-scaler:stream:"
+
+// streamLeaseScript takes or renews the lease on KEYS[1] for owner ARGV[1], for ARGV[2]
+// milliseconds, unless another replica holds it. A non-empty ARGV[3] records the activation
+// state just pushed. It returns whether the lease is held and the last state pushed for the
+// trigger by any replica, "" when none was.
+var streamLeaseScript = redis.NewScript(`
+local owner = redis.call('HGET', KEYS[1], 'owner')
+if owner and owner ~= ARGV[1] then
+	return {0, ''}
+end
+redis.call('HSET', KEYS[1], 'owner', ARGV[1])
+if ARGV[3] ~= '' then
+	redis.call('HSET', KEYS[1], 'active', ARGV[3])
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return {1, redis.call('HGET', KEYS[1], 'active') or ''}
+`)
+
+// streamReleaseScript gives up the lease on KEYS[1] if ARGV[1] holds it, keeping the last
+// pushed state for the replica taking over
+var streamReleaseScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'owner') == ARGV[1] then
+	redis.call('HDEL', KEYS[1], 'owner')
+end
+return 1
+`)
+
+// streamLeases coordinates StreamIsActive across scaler replicas. KEDA may hold a stream
+// for the same trigger on more than one replica, e.g. while a stale connection to a
+// restarted pod lingers, and each would otherwise check the queue and push on its own.
+// With leases, one replica per trigger checks and pushes while the others stand by, and
+// the one taking over after a failure resumes from the last state pushed instead of
+// pushing it again.
+type streamLeases struct {
+	owner  string
+	ttl    time.Duration
+	prefix string
+}
+
+// newStreamLeases reads STREAM_LEASE_ENABLED, STREAM_LEASE_TTL and STREAM_LEASE_PREFIX,
+// returning nil when leases are disabled. The owner is the pod name, which Kubernetes
+// sets as the hostname, and the process ID.
+func newStreamLeases(pollInterval time.Duration) *streamLeases {
+	if !getEnvBool("STREAM_LEASE_ENABLED", false) {
+		return nil
+	}
+	ttl := getEnvDuration("STREAM_LEASE_TTL", 3*pollInterval)
+	if ttl <= pollInterval {
+		fatal("Invalid STREAM_LEASE_TTL: must be longer than STREAM_POLL_INTERVAL, which renews it", "value", ttl)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		fatal("STREAM_LEASE_ENABLED requires a hostname to identify the replica", "error", err)
+	}
+	return &streamLeases{
+		owner:  host + "/" + strconv.Itoa(os.Getpid()),
+		ttl:    ttl,
+		prefix: getEnvDefault("STREAM_LEASE_PREFIX", defaultStreamLeasePrefix),
+	}
+}
+
+// key returns the lease key of a trigger, or "" when leases are disabled. The key set is
+// part of it, since a ScaledObject may have several triggers on different queues.
+func (l *streamLeases) key(ref *pb.ScaledObjectRef, keys queueKeys) string {
+	if l == nil {
+		return ""
+	}
+	h := fnv.New64a()
+	h.Write([]byte(keys.id()))
+	return fmt.Sprintf("%s%s/%s/%x", l.prefix, ref.Namespace, ref.Name, h.Sum64())
+}
+
+// holdStreamLease takes or renews a stream lease, recording pushed ("true" or "false")
+// unless it is empty, and returns whether this replica holds it and the last pushed state
+func (s *server) holdStreamLease(ctx context.Context, key, pushed string) (held bool, last string, err error) {
+	var reply []interface{}
+	err = s.redisOp(ctx, "stream_lease", func(ctx context.Context) (err error) {
+		reply, err = streamLeaseScript.Run(ctx, s.redisClient, []string{key},
+			s.streamLeases.owner, s.streamLeases.ttl.Milliseconds(), pushed).Slice()
+		return err
+	})
+	if err != nil {
+		return false, "", fmt.Errorf("failed to hold stream lease '%s': %w", key, err)
+	}
+	if len(reply) != 2 {
+		return false, "", fmt.Errorf("unexpected stream lease reply: %v", reply)
+	}
+	held = reply[0] == int64(1)
+	last, _ = reply[1].(string)
+	return held, last, nil
+}
+
+// releaseStreamLease gives up a stream lease when its stream closes, so another replica
+// can take over without waiting for it to expire
+func (s *server) releaseStreamLease(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.redisOpTimeout)
+	defer cancel()
+	s.redisOp(ctx, "stream_lease_release", func(ctx context.Context) error {
+		return streamReleaseScript.Run(ctx, s.redisClient, []string{key}, s.streamLeases.owner).Err()
+	})
+}
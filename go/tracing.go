@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// defaultServiceName is the service.name reported in traces unless OTEL_SERVICE_NAME or
+// OTEL_RESOURCE_ATTRIBUTES sets one
+const defaultServiceName = "redis-bull-scaler"
+
+// redisHooks are added to every Redis client the scaler opens. setupTracing fills it in
+// before the first client is created.
+var redisHooks []redis.Hook
+
+// tracingRequested reports whether the standard OTEL env vars ask for traces to be exported
+func tracingRequested() bool {
+	if getEnvBool("OTEL_SDK_DISABLED", false) {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// instrumentRedis adds redisHooks to a newly created client
+func instrumentRedis(client redisCmdable) {
+	hooked, ok := client.(interface{ AddHook(redis.Hook) })
+	if !ok {
+		return
+	}
+	for _, hook := range redisHooks {
+		hooked.AddHook(hook)
+	}
+}
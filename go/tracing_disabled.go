@@ -0,0 +1,19 @@
+//go:build !otel
+
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"google.golang.org/grpc"
+)
+
+// setupTracing is a no-op unless the binary is built with the otel tag, which keeps the
+// OpenTelemetry SDK out of default builds
+func setupTracing() ([]grpc.ServerOption, func(context.Context) error) {
+	if tracingRequested() {
+		slog.Warn("OTEL_EXPORTER_OTLP_ENDPOINT is set, but tracing requires a build with -tags otel; no traces are exported")
+	}
+	return nil, func(context.Context) error { return nil }
+}
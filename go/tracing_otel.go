@@ -0,0 +1,51 @@
+//go:build otel
+
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/go-redis/redis/extra/redisotel/v8"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+)
+
+// setupTracing exports traces over OTLP/gRPC when an OTLP endpoint is configured. The
+// exporter, sampler and resource read the standard OTEL_* env vars. It returns the gRPC
+// server options that trace every RPC, continuing the trace KEDA propagates, and registers
+// the Redis hook that adds a span per command or pipeline. The returned function flushes
+// buffered spans on shutdown.
+func setupTracing() ([]grpc.ServerOption, func(context.Context) error) {
+	if !tracingRequested() {
+		return nil, func(context.Context) error { return nil }
+	}
+
+	ctx := context.Background()
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		fatal("Failed to create OTLP trace exporter", "error", err)
+	}
+	// Attributes from the env are merged last, so OTEL_SERVICE_NAME wins over the default
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", defaultServiceName)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		fatal("Invalid OpenTelemetry resource attributes", "error", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	redisHooks = append(redisHooks, redisotel.NewTracingHook())
+
+	slog.Info("OpenTelemetry tracing enabled")
+	return []grpc.ServerOption{grpc.StatsHandler(otelgrpc.NewServerHandler())}, provider.Shutdown
+}
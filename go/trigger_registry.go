@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/avishay/redis-bull-scaler/externalscaler"
+)
+
+// triggerRecord is what the scaler last answered for one ScaledObject
+type triggerRecord struct {
+	Namespace   string        `json:"namespace"`
+	Name        string        `json:"name"`
+	Queue       string        `json:"queue,omitempty"`
+	LastSeen    time.Time     `json:"lastSeen"`
+	IsActive    *bool         `json:"isActive,omitempty"`
+	Metrics     []checkMetric `json:"metrics,omitempty"`
+	LastError   string        `json:"lastError,omitempty"`
+	LastErrorAt *time.Time    `json:"lastErrorAt,omitempty"`
+}
+
+// triggerRegistry keeps the ScaledObjects served recently, for /debug/state. ScaledObjects
+// not polled for idleTTL are dropped, like per-queue state.
+type triggerRegistry struct {
+	mu      sync.Mutex
+	idleTTL time.Duration
+	records map[string]*triggerRecord
+}
+
+// newTriggerRegistry creates an empty registry
+func newTriggerRegistry(idleTTL time.Duration) *triggerRegistry {
+	return &triggerRegistry{idleTTL: idleTTL, records: make(map[string]*triggerRecord)}
+}
+
+// record updates the entry for ref after a call. update fills in the call's result and
+// runs only when the call succeeded; otherwise the error is recorded and earlier results
+// are kept, so a failing ScaledObject still shows what it last reported.
+func (r *triggerRegistry) record(ref *pb.ScaledObjectRef, err error, update func(*triggerRecord)) {
+	if ref == nil {
+		return
+	}
+	// The queue is resolved apart from validation, so it is known even for invalid metadata
+	keys, _ := resolveQueueKeys(ref.Namespace, withMetadataDefaults(ref.ScalerMetadata))
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := ref.Namespace + "/" + ref.Name
+	rec, ok := r.records[id]
+	if !ok {
+		rec = &triggerRecord{Namespace: ref.Namespace, Name: ref.Name}
+		r.records[id] = rec
+	}
+	rec.Queue = keys.name()
+	rec.LastSeen = now
+	if err != nil {
+		rec.LastError = err.Error()
+		rec.LastErrorAt = &now
+		return
+	}
+	rec.LastError, rec.LastErrorAt = "", nil
+	update(rec)
+}
+
+// recordActive records the result of an IsActive call
+func (r *triggerRegistry) recordActive(ref *pb.ScaledObjectRef, resp *pb.IsActiveResponse, err error) {
+	r.record(ref, err, func(rec *triggerRecord) {
+		result := resp.Result
+		rec.IsActive = &result
+	})
+}
+
+// recordMetrics records the result of a GetMetrics call
+func (r *triggerRegistry) recordMetrics(ref *pb.ScaledObjectRef, resp *pb.GetMetricsResponse, err error) {
+	r.record(ref, err, func(rec *triggerRecord) {
+		rec.Metrics = rec.Metrics[:0]
+		for _, value := range resp.MetricValues {
+			rec.Metrics = append(rec.Metrics, checkMetric{Name: value.MetricName, Value: value.MetricValueFloat})
+		}
+	})
+}
+
+// snapshot returns copies of the records of ScaledObjects seen within idleTTL, sorted by
+// namespace and name
+func (r *triggerRegistry) snapshot() []triggerRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	records := make([]triggerRecord, 0, len(r.records))
+	for id, rec := range r.records {
+		if r.idleTTL > 0 && now.Sub(rec.LastSeen) > r.idleTTL {
+			delete(r.records, id)
+			continue
+		}
+		copied := *rec
+		copied.Metrics = append([]checkMetric(nil), rec.Metrics...)
+		records = append(records, copied)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Namespace != records[j].Namespace {
+			return records[i].Namespace < records[j].Namespace
+		}
+		return records[i].Name < records[j].Name
+	})
+	return records
+}
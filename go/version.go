@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Build information, set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// versionHeader carries the scaler version in the response metadata of every gRPC call
+const versionHeader = "x-scaler-version"
+
+// buildInfoGauge is always 1; its labels identify the running build, so it can be joined
+// onto any other metric in a query
+var buildInfoGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "scaler_build_info",
+		Help: "Always 1, labeled with the version, git commit, build date and Go version of the scaler.",
+	},
+	[]string{"version", "commit", "build_date", "go_version"},
+)
+
+func init() {
+	prometheus.MustRegister(buildInfoGauge)
+	buildInfoGauge.WithLabelValues(version, commit, buildDate, runtime.Version()).Set(1)
+}
+
+// versionString describes the build on one line, for the version subcommand
+func versionString() string {
+	return fmt.Sprintf("redis-bull-scaler %s (commit %s, built %s, %s)", version, commit, buildDate, runtime.Version())
+}
+
+// buildInfoAttrs returns the build information as structured log attributes
+func buildInfoAttrs() []any {
+	return []any{"version", version, "commit", commit, "buildDate", buildDate, "goVersion", runtime.Version()}
+}
+
+// versionHandler serves the build information as JSON on /version
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"version":   version,
+		"commit":    commit,
+		"buildDate": buildDate,
+		"goVersion": runtime.Version(),
+	})
+}
+
+// versionUnary sends the scaler version in the response headers of unary calls, so the
+// build behind a scaling decision can be told from the KEDA side
+func versionUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	grpc.SetHeader(ctx, metadata.Pairs(versionHeader, version))
+	return handler(ctx, req)
+}
+
+// versionStream sends the scaler version in the response headers of streams
+func versionStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ss.SetHeader(metadata.Pairs(versionHeader, version))
+	return handler(srv, ss)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// jobKeyPrefix derives the BullMQ job hash prefix ("bull:my-queue:") from the wait list key
+func jobKeyPrefix(waitList string) string {
+	return strings.TrimSuffix(waitList, "wait")
+}
+
+// readJobTimestamps returns the timestamp and processedOn fields (unix ms) of a job hash.
+// processedOn is 0 when the job has not been picked up yet.
+func (s *server) readJobTimestamps(ctx context.Context, client redisCmdable, jobKey string) (int64, int64, error) {
+	if err := checkKeyAllowed(jobKey); err != nil {
+		return 0, 0, err
+	}
+	var values []interface{}
+	err := s.redisOp(ctx, "hmget", func(ctx context.Context) (err error) {
+		values, err = client.HMGet(ctx, jobKey, "timestamp", "processedOn").Result()
+		return err
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read job hash '%s': %w", jobKey, err)
+	}
+
+	parsed := make([]int64, len(values))
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		parsed[i], _ = strconv.ParseInt(str, 10, 64)
+	}
+	return parsed[0], parsed[1], nil
+}
+
+// readWaitLatency approximates how long jobs sit in wait before becoming active. When
+// several queues are aggregated, the largest latency across them is reported.
+func (s *server) readWaitLatency(ctx context.Context, keys queueKeys) (latency time.Duration, ok bool, err error) {
+	client := s.clientFor(keys.redis)
+	for i, waitList := range keys.wait {
+		activeList := ""
+		if i < len(keys.active) {
+			activeList = keys.active[i]
+		}
+
+		queueLatency, queueOk, err := s.readQueueWaitLatency(ctx, client, waitList, activeList)
+		if err != nil {
+			return 0, false, err
+		}
+		if queueOk && queueLatency > latency {
+			latency = queueLatency
+		}
+		ok = ok || queueOk
+	}
+	return latency, ok, nil
+}
+
+// readQueueWaitLatency approximates the wait latency of a single queue. It reads
+// only the oldest waiting job (tail of the wait list) and the newest active job (head of
+// the active list). While jobs are waiting, the oldest job's age is reported; otherwise
+// the pickup latency of the most recently started job. ok is false when neither is known.
+func (s *server) readQueueWaitLatency(ctx context.Context, client redisCmdable, waitList, activeList string) (latency time.Duration, ok bool, err error) {
+	prefix := jobKeyPrefix(waitList)
+
+	var oldestWaitID string
+	err = s.redisOp(ctx, "lindex", func(ctx context.Context) (err error) {
+		oldestWaitID, err = client.LIndex(ctx, waitList, -1).Result()
+		return err
+	})
+	if err != nil && err != redis.Nil {
+		return 0, false, fmt.Errorf("failed to read oldest job in wait list '%s': %w", waitList, err)
+	}
+	if oldestWaitID != "" {
+		timestamp, _, err := s.readJobTimestamps(ctx, client, prefix+oldestWaitID)
+		if err != nil {
+			return 0, false, err
+		}
+		if timestamp > 0 {
+			return time.Since(time.UnixMilli(timestamp)), true, nil
+		}
+	}
+
+	if activeList == "" {
+		return 0, false, nil
+	}
+
+	var newestActiveID string
+	err = s.redisOp(ctx, "lindex", func(ctx context.Context) (err error) {
+		newestActiveID, err = client.LIndex(ctx, activeList, 0).Result()
+		return err
+	})
+	if err != nil && err != redis.Nil {
+		return 0, false, fmt.Errorf("failed to read newest job in active list '%s': %w", activeList, err)
+	}
+	if newestActiveID != "" {
+		timestamp, processedOn, err := s.readJobTimestamps(ctx, client, prefix+newestActiveID)
+		if err != nil {
+			return 0, false, err
+		}
+		if timestamp > 0 && processedOn >= timestamp {
+			return time.Duration(processedOn-timestamp) * time.Millisecond, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// delayedScoreShift is the factor BullMQ multiplies a delayed job's due time (unix ms) by
+// to make room for a 12-bit counter in its sorted set score
+const delayedScoreShift = 0x1000
+
+// readOldestJobAge returns the age of the oldest job that is ready to run across the
+// queue's keys: the oldest job in each wait list, measured from its enqueue timestamp, and
+// the earliest delayed job whose due time has already passed. It is 0 when nothing waits.
+func (s *server) readOldestJobAge(ctx context.Context, keys queueKeys) (time.Duration, error) {
+	var oldest time.Duration
+	client := s.clientFor(keys.redis)
+
+	for _, waitList := range keys.wait {
+		var oldestID string
+		err := s.redisOp(ctx, "lindex", func(ctx context.Context) (err error) {
+			oldestID, err = client.LIndex(ctx, waitList, -1).Result()
+			return err
+		})
+		if err != nil && err != redis.Nil {
+			return 0, s.keyReadError("waitList", waitList, "list", err)
+		}
+		if oldestID == "" {
+			continue
+		}
+		timestamp, _, err := s.readJobTimestamps(ctx, client, jobKeyPrefix(waitList)+oldestID)
+		if err != nil {
+			return 0, err
+		}
+		if age := time.Since(time.UnixMilli(timestamp)); timestamp > 0 && age > oldest {
+			oldest = age
+		}
+	}
+
+	for _, delayedSet := range keys.delayed {
+		var earliest []redis.Z
+		err := s.redisOp(ctx, "zrange", func(ctx context.Context) (err error) {
+			earliest, err = client.ZRangeWithScores(ctx, delayedSet, 0, 0).Result()
+			return err
+		})
+		if err != nil {
+			return 0, s.keyReadError("delayedSet", delayedSet, "sorted set", err)
+		}
+		if len(earliest) == 0 {
+			continue
+		}
+		dueAt := time.UnixMilli(int64(earliest[0].Score) / delayedScoreShift)
+		if overdue := time.Since(dueAt); overdue > oldest {
+			oldest = overdue
+		}
+	}
+
+	return oldest, nil
+}